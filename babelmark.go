@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// BabelmarkResult is the JSON document returned by pandoc-server's
+// /babelmark end point.
+type BabelmarkResult struct {
+	// HTML is the rendered text
+	HTML string `json:"html"`
+	// Version is the version of pandoc used to render the text
+	Version string `json:"version"`
+}
+
+// Babelmark sends text to pandoc-server's /babelmark end point and
+// returns the rendering along with the pandoc version. If from is an
+// empty string the configuration's From value is used.
+//
+// ```
+//
+//	cfg := pandoc_client.Config{}
+//	result, err := cfg.Babelmark("*hello* _world_", "commonmark")
+//	// ... handle error
+//	fmt.Printf("pandoc %s\n%s\n", result.Version, result.HTML)
+//
+// ```
+func (cfg *Config) Babelmark(text string, from string) (*BabelmarkResult, error) {
+	if from == "" {
+		from = cfg.From
+	}
+	q := url.Values{}
+	q.Set("text", text)
+	if from != "" {
+		q.Set("from", from)
+	}
+	if cfg.Standalone {
+		q.Set("standalone", "")
+	}
+	src, err := cfg.send(context.Background(), "GET", "/babelmark", q, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	result := new(BabelmarkResult)
+	if err := json.Unmarshal(src, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
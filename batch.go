@@ -0,0 +1,157 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+)
+
+const (
+	// DefaultBatchSize is used when Config.BatchSize is not set.
+	DefaultBatchSize = 100
+	// DefaultBatchBytes is used when Config.BatchBytes is not set.
+	DefaultBatchBytes = 8 * 1024 * 1024
+)
+
+// BatchResult holds the outcome of converting one document sent to the
+// /batch end point. Index is the position of the document in the
+// slice passed to ConvertBatch.
+type BatchResult struct {
+	Result
+	Index int
+	Err   error
+}
+
+// ConvertBatch reads each input and sends them to the Pandoc Server's
+// /batch end point using the configuration settings. Large slices are
+// split into several POSTs based on BatchSize and BatchBytes. The
+// results are returned in the same order as the inputs.
+//
+// Errors for individual documents are reported in their BatchResult.
+// The error returned is the first one which prevented a batch from
+// being converted, the other batches are still sent.
+//
+// ```
+//
+//	inputs := []io.Reader{}
+//	for _, fName := range fNames {
+//		src, err := os.ReadFile(fName)
+//		// ... handle error
+//		inputs = append(inputs, bytes.NewReader(src))
+//	}
+//	results, err := cfg.ConvertBatch(inputs)
+//	// ... handle error
+//	for _, result := range results {
+//		if result.Err != nil {
+//			log.Printf("%s: %s", fNames[result.Index], result.Err)
+//		}
+//	}
+//
+// ```
+func (cfg *Config) ConvertBatch(inputs []io.Reader) ([]*BatchResult, error) {
+	results := make([]*BatchResult, len(inputs))
+	docs := make([][]byte, len(inputs))
+	pending := []int{}
+	for i, input := range inputs {
+		results[i] = &BatchResult{Index: i}
+		src, err := io.ReadAll(input)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		docs[i], err = json.Marshal(cfg.newRequest(src, "", nil))
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	batchBytes := cfg.BatchBytes
+	if batchBytes <= 0 {
+		batchBytes = DefaultBatchBytes
+	}
+	var firstErr error
+	for len(pending) > 0 {
+		// Always take at least one document so oversized documents
+		// are sent by themselves.
+		n, size := 1, len(docs[pending[0]])+2
+		for n < len(pending) && n < batchSize {
+			size += len(docs[pending[n]]) + 1
+			if size > batchBytes {
+				break
+			}
+			n++
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+		if err := cfg.postBatch(batch, docs, results); err != nil {
+			for _, i := range batch {
+				results[i].Err = err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return results, firstErr
+}
+
+// postBatch sends the documents in docs listed by batch to the /batch
+// end point and records the outputs in results.
+func (cfg *Config) postBatch(batch []int, docs [][]byte, results []*BatchResult) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString("[")
+	for j, i := range batch {
+		if j > 0 {
+			buf.WriteString(",")
+		}
+		buf.Write(docs[i])
+	}
+	buf.WriteString("]")
+
+	src, err := cfg.send(context.Background(), "POST", "/batch", nil, "application/json", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	outputs := []*output{}
+	if err := json.Unmarshal(src, &outputs); err != nil {
+		return err
+	}
+	if len(outputs) != len(batch) {
+		return fmt.Errorf("expected %d outputs from batch end point, got %d", len(batch), len(outputs))
+	}
+	for j, i := range batch {
+		result, err := outputs[j].result()
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Result = *result
+	}
+	if cfg.Verbose {
+		log.Printf("%d documents returned successful from Batch Endpoint", len(batch))
+	}
+	return nil
+}
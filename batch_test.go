@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConvertBatch(t *testing.T) {
+	fp := newFakePandoc(t)
+	cfg := fp.config()
+	cfg.BatchSize = 2
+
+	texts := []string{"one", "two", "FAIL three", "four", "five"}
+	inputs := []io.Reader{}
+	for _, text := range texts {
+		inputs = append(inputs, bytes.NewReader([]byte(text)))
+	}
+	results, err := cfg.ConvertBatch(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(texts) {
+		t.Fatalf("expected %d results, got %d", len(texts), len(results))
+	}
+	if n := atomic.LoadInt32(&fp.batches); n != 3 {
+		t.Errorf("expected 3 POSTs to /batch, got %d", n)
+	}
+	for i, result := range results {
+		if result.Index != i {
+			t.Errorf("expected index %d, got %d", i, result.Index)
+		}
+		if texts[i] == "FAIL three" {
+			if result.Err == nil {
+				t.Errorf("expected an error for %q", texts[i])
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("%q: %s", texts[i], result.Err)
+		}
+		if expected := "<p>" + texts[i] + "</p>"; string(result.Output) != expected {
+			t.Errorf("expected %q, got %q", expected, result.Output)
+		}
+	}
+	if cfg.Text != "" {
+		t.Errorf("expected cfg.Text to be left empty, got %q", cfg.Text)
+	}
+}
+
+func TestConvertBatchBytes(t *testing.T) {
+	fp := newFakePandoc(t)
+	cfg := fp.config()
+	// Small enough that every document is sent by itself.
+	cfg.BatchBytes = 10
+	inputs := []io.Reader{
+		bytes.NewReader([]byte("one")),
+		bytes.NewReader([]byte("two")),
+		bytes.NewReader([]byte("three")),
+	}
+	results, err := cfg.ConvertBatch(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fp.batches); n != 3 {
+		t.Errorf("expected 3 POSTs to /batch, got %d", n)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Error(result.Err)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheBackend stores the converted documents held by a Cache. The keys
+// are hex encoded SHA-256 hashes. Implementations must be safe for
+// concurrent use.
+type CacheBackend interface {
+	// Get returns the value stored for key and true if found
+	Get(key string) ([]byte, bool)
+	// Put stores the value for key
+	Put(key string, val []byte) error
+	// Delete removes the value stored for key
+	Delete(key string) error
+	// Clear removes every value
+	Clear() error
+}
+
+// CacheStats counts the lookups made in a Cache.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// Cache sits in front of the root end point and returns the earlier
+// result when the same request is sent again. Requests are keyed on a
+// hash of the request JSON and pandoc-server's version so upgrading
+// pandoc doesn't return stale results. Each server's version is asked
+// for when the cache is first used with it, and again on the next lookup
+// if asking fails.
+//
+// ```
+//
+//	cfg.Cache = pandoc_client.NewCache(pandoc_client.NewMemoryCache(1000))
+//	src, err := cfg.Convert(bytes.NewReader(txt))
+//	// ... handle error
+//	stats := cfg.Cache.Stats()
+//	log.Printf("%d hits, %d misses", stats.Hits, stats.Misses)
+//
+// ```
+type Cache struct {
+	Backend CacheBackend
+
+	hits, misses int64
+	mu           sync.Mutex
+	// versions maps each server's address to its version
+	versions map[string]string
+}
+
+// NewCache returns a Cache storing results in backend.
+func NewCache(backend CacheBackend) *Cache {
+	return &Cache{Backend: backend}
+}
+
+// Stats returns the number of cache hits and misses so far.
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+	}
+}
+
+// Invalidate removes the result of converting src with the configuration
+// using Convert or ConvertResult. See InvalidateFile for the results of
+// Walk.
+func (c *Cache) Invalidate(cfg *Config, src []byte) error {
+	key, err := c.key(cfg, cfg.newRequest(src, "", nil))
+	if err != nil {
+		return err
+	}
+	return c.Backend.Delete(key)
+}
+
+// InvalidateFile removes the cached results of converting fName the way
+// Walk converts it when walking startPath for files ending in fromExt,
+// one for each of Profiles. The file is read and, when CollectResources
+// is set, its resources are collected so the requests match the ones Walk
+// sent.
+func (cfg *Config) InvalidateFile(startPath string, fromExt string, fName string) error {
+	if cfg.Cache == nil {
+		return nil
+	}
+	w, err := cfg.newWalker(startPath, fromExt, "")
+	if err != nil {
+		return err
+	}
+	formats, err := cfg.sourceFormats(fromExt)
+	if err != nil {
+		return err
+	}
+	from, ok := formats[strings.ToLower(path.Ext(fName))]
+	if !ok {
+		return fmt.Errorf("%q is not a file Walk converts", fName)
+	}
+	job := &walkJob{fName: fName, from: from}
+	for _, target := range w.targets {
+		job.outputs = append(job.outputs, &walkOutput{target: target})
+	}
+	if err := cfg.prepareJob(w, job); err != nil {
+		return err
+	}
+	for _, out := range job.outputs {
+		key, err := cfg.Cache.key(out.target.cfg, out.req)
+		if err != nil {
+			return err
+		}
+		if err := cfg.Cache.Backend.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge removes every result in the cache.
+func (c *Cache) Purge() error {
+	return c.Backend.Clear()
+}
+
+// serverVersion returns the version of the pandoc-server cfg talks to.
+// Only successful answers are remembered so a failure is retried.
+func (c *Cache) serverVersion(cfg *Config) (string, error) {
+	u, err := cfg.endpoint("/")
+	if err != nil {
+		return "", err
+	}
+	addr := u + " " + cfg.socketPath()
+	c.mu.Lock()
+	version, ok := c.versions[addr]
+	c.mu.Unlock()
+	if ok {
+		return version, nil
+	}
+	v, err := cfg.ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("can't use cache without pandoc-server's version, %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.versions == nil {
+		c.versions = map[string]string{}
+	}
+	c.versions[addr] = v.String()
+	return v.String(), nil
+}
+
+// key returns the cache key of the request.
+func (c *Cache) key(cfg *Config, req *request) (string, error) {
+	version, err := c.serverVersion(cfg)
+	if err != nil {
+		return "", err
+	}
+	src, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	return hashOf(append([]byte(version+"\n"), src...)), nil
+}
+
+// get returns the cached result of the request. An error is returned if
+// the key can't be worked out.
+func (c *Cache) get(cfg *Config, req *request) (string, *Result, bool, error) {
+	key, err := c.key(cfg, req)
+	if err != nil {
+		return "", nil, false, err
+	}
+	if src, ok := c.Backend.Get(key); ok {
+		result := new(Result)
+		if err := json.Unmarshal(src, result); err == nil {
+			atomic.AddInt64(&c.hits, 1)
+			return key, result, true, nil
+		}
+	}
+	atomic.AddInt64(&c.misses, 1)
+	return key, nil, false, nil
+}
+
+// put stores the result under key.
+func (c *Cache) put(key string, result *Result) error {
+	src, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return c.Backend.Put(key, src)
+}
+
+// cachedConvert is convert with the configuration's Cache in front of it.
+func (cfg *Config) cachedConvert(ctx context.Context, req *request) (*Result, error) {
+	key, result, ok, err := cfg.Cache.get(cfg, req)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return result, nil
+	}
+	result, err = cfg.postRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: The result is still good if it can't be cached.
+	if err := cfg.Cache.put(key, result); err != nil && cfg.Verbose {
+		log.Printf("can't cache the result, %s", err)
+	}
+	return result, nil
+}
+
+// MemoryCache is a CacheBackend holding the most recently used
+// results in memory.
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+// memoryEntry is an element in MemoryCache's order list.
+type memoryEntry struct {
+	key string
+	val []byte
+}
+
+// NewMemoryCache returns a MemoryCache holding up to maxEntries results,
+// the least recently used result is dropped when it is full.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+func (mc *MemoryCache) Get(key string) ([]byte, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if e, ok := mc.entries[key]; ok {
+		mc.order.MoveToFront(e)
+		return e.Value.(*memoryEntry).val, true
+	}
+	return nil, false
+}
+
+func (mc *MemoryCache) Put(key string, val []byte) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if e, ok := mc.entries[key]; ok {
+		e.Value.(*memoryEntry).val = val
+		mc.order.MoveToFront(e)
+		return nil
+	}
+	mc.entries[key] = mc.order.PushFront(&memoryEntry{key: key, val: val})
+	for mc.maxEntries > 0 && mc.order.Len() > mc.maxEntries {
+		e := mc.order.Back()
+		mc.order.Remove(e)
+		delete(mc.entries, e.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+func (mc *MemoryCache) Delete(key string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if e, ok := mc.entries[key]; ok {
+		mc.order.Remove(e)
+		delete(mc.entries, key)
+	}
+	return nil
+}
+
+func (mc *MemoryCache) Clear() error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.entries = map[string]*list.Element{}
+	mc.order.Init()
+	return nil
+}
+
+// DirCache is a CacheBackend storing results as files in a directory.
+type DirCache struct {
+	dName string
+}
+
+// NewDirCache returns a DirCache storing results in dName. The
+// directory is created when the first result is stored.
+func NewDirCache(dName string) *DirCache {
+	return &DirCache{dName: dName}
+}
+
+// path returns the file name for key, results are spread across
+// sub directories named by the first two characters of the key.
+func (dc *DirCache) path(key string) string {
+	if len(key) < 3 {
+		return filepath.Join(dc.dName, key)
+	}
+	return filepath.Join(dc.dName, key[0:2], key)
+}
+
+func (dc *DirCache) Get(key string) ([]byte, bool) {
+	src, err := os.ReadFile(dc.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return src, true
+}
+
+func (dc *DirCache) Put(key string, val []byte) error {
+	fName := dc.path(key)
+	if err := os.MkdirAll(filepath.Dir(fName), 0775); err != nil {
+		return err
+	}
+	// NOTE: Write to a temporary file and rename it so readers never see
+	// a partial result.
+	tmp, err := os.CreateTemp(filepath.Dir(fName), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(val); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fName)
+}
+
+func (dc *DirCache) Delete(key string) error {
+	if err := os.Remove(dc.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Clear removes the results stored in the directory along with any left
+// over temporary files. Nothing else is removed so pointing a DirCache
+// at a directory holding other files is safe.
+func (dc *DirCache) Clear() error {
+	entries, err := os.ReadDir(dc.dName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		name := filepath.Join(dc.dName, entry.Name())
+		if !entry.IsDir() {
+			if isCacheKey(entry.Name()) {
+				if err := os.Remove(name); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if len(entry.Name()) != 2 || !isCacheKey(entry.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !file.IsDir() && (isCacheKey(file.Name()) || strings.HasPrefix(file.Name(), ".tmp-")) {
+				if err := os.Remove(filepath.Join(name, file.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		// NOTE: The directory is only removed if nothing else is in it.
+		os.Remove(name)
+	}
+	return nil
+}
+
+// isCacheKey returns true if name looks like a cache key, keys are hex
+// encoded hashes.
+func isCacheKey(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMemoryCache(t *testing.T) {
+	mc := NewMemoryCache(2)
+	mc.Put("a", []byte("one"))
+	mc.Put("b", []byte("two"))
+	// Using "a" makes "b" the least recently used
+	if val, ok := mc.Get("a"); !ok || string(val) != "one" {
+		t.Errorf("expected one, got %q", val)
+	}
+	mc.Put("c", []byte("three"))
+	if _, ok := mc.Get("b"); ok {
+		t.Errorf("expected b to be dropped")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := mc.Get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+	mc.Delete("a")
+	if _, ok := mc.Get("a"); ok {
+		t.Errorf("expected a to be deleted")
+	}
+	mc.Clear()
+	if _, ok := mc.Get("c"); ok {
+		t.Errorf("expected c to be cleared")
+	}
+}
+
+func TestDirCache(t *testing.T) {
+	dc := NewDirCache(filepath.Join(t.TempDir(), "cache"))
+	if _, ok := dc.Get("abcdef"); ok {
+		t.Errorf("expected an empty cache")
+	}
+	if err := dc.Put("abcdef", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := dc.Get("abcdef"); !ok || string(val) != "one" {
+		t.Errorf("expected one, got %q", val)
+	}
+	if err := dc.Delete("abcdef"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := dc.Get("abcdef"); ok {
+		t.Errorf("expected abcdef to be deleted")
+	}
+	dc.Put("123456", []byte("two"))
+	// Files which aren't cache entries must survive Clear
+	writeFiles(t, dc.dName, map[string]string{
+		"notes.txt":    "mine",
+		"12/README":    "mine too",
+		"docs/abcdef0": "not a cache directory",
+	})
+	if err := dc.Clear(); err != nil {
+		t.Error(err)
+	}
+	if _, ok := dc.Get("123456"); ok {
+		t.Errorf("expected the cache to be cleared")
+	}
+	for _, name := range []string{"notes.txt", "12/README", "docs/abcdef0"} {
+		if _, err := os.Stat(filepath.Join(dc.dName, name)); err != nil {
+			t.Errorf("expected %s to be kept, %s", name, err)
+		}
+	}
+}
+
+func TestCacheVersionRetry(t *testing.T) {
+	fp := newFakePandoc(t)
+	fp.version = ""
+	cfg := fp.config()
+	cfg.Cache = NewCache(NewMemoryCache(10))
+	if _, err := cfg.Convert(strings.NewReader("Hello")); err == nil {
+		t.Errorf("expected an error when the server's version is unknown")
+	}
+	fp.version = "3.1.2"
+	for i := 0; i < 2; i++ {
+		if _, err := cfg.Convert(strings.NewReader("Hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cfg.Cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("expected 1 hit and 1 miss once the version is known, got %+v", stats)
+	}
+
+	// Each server is asked for its version
+	other := newFakePandoc(t)
+	other.version = "3.0"
+	ocfg := other.config()
+	ocfg.Cache = cfg.Cache
+	if _, err := ocfg.Convert(strings.NewReader("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	if stats := cfg.Cache.Stats(); stats.Misses != 2 {
+		t.Errorf("expected a miss for a different server version, got %+v", stats)
+	}
+}
+
+func TestCachedConvert(t *testing.T) {
+	fp := newFakePandoc(t)
+	for _, backend := range []CacheBackend{
+		NewMemoryCache(10),
+		NewDirCache(t.TempDir()),
+	} {
+		cfg := fp.config()
+		cfg.Cache = NewCache(backend)
+		before := atomic.LoadInt32(&fp.converts)
+		for i := 0; i < 3; i++ {
+			src, err := cfg.Convert(strings.NewReader("Hello WARN"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := "<p>Hello WARN</p>"; string(src) != expected {
+				t.Errorf("expected %q, got %q", expected, src)
+			}
+		}
+		result, err := cfg.ConvertResult(strings.NewReader("Hello WARN"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Messages) != 1 {
+			t.Errorf("expected cached messages, got %+v", result.Messages)
+		}
+		if n := atomic.LoadInt32(&fp.converts) - before; n != 1 {
+			t.Errorf("expected 1 conversion, got %d", n)
+		}
+		if stats := cfg.Cache.Stats(); stats.Hits != 3 || stats.Misses != 1 {
+			t.Errorf("expected 3 hits and 1 miss, got %+v", stats)
+		}
+
+		// A different configuration is a different request
+		cfg.Standalone = true
+		if _, err := cfg.Convert(strings.NewReader("Hello WARN")); err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.Cache.Invalidate(cfg, []byte("Hello WARN")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cfg.Convert(strings.NewReader("Hello WARN")); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&fp.converts) - before; n != 3 {
+			t.Errorf("expected 3 conversions, got %d", n)
+		}
+		if err := cfg.Cache.Purge(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cfg.Convert(strings.NewReader("Hello WARN")); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&fp.converts) - before; n != 4 {
+			t.Errorf("expected 4 conversions, got %d", n)
+		}
+	}
+}
+
+func TestInvalidateFile(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"index.md":        "![Logo](images/logo.png)",
+		"about.md":        "about",
+		"images/logo.png": "PNG",
+	})
+	cfg := fp.config()
+	cfg.Cache = NewCache(NewMemoryCache(10))
+	cfg.CollectResources = true
+	cfg.Profiles = []*Profile{
+		{Name: "web", To: "html5", Ext: ".html"},
+		{Name: "word", To: "docx", Ext: ".docx"},
+	}
+	for i := 0; i < 2; i++ {
+		if err := cfg.Walk(root, ".md", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := atomic.LoadInt32(&fp.converts)
+	if err := cfg.InvalidateFile(root, ".md", filepath.Join(root, "index.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Walk(root, ".md", ""); err != nil {
+		t.Fatal(err)
+	}
+	// Only index.md is converted again, once for each profile
+	if n := atomic.LoadInt32(&fp.converts) - before; n != 2 {
+		t.Errorf("expected 2 conversions, got %d", n)
+	}
+	if err := cfg.InvalidateFile(root, ".md", filepath.Join(root, "images/logo.png")); err == nil {
+		t.Errorf("expected an error for a file Walk doesn't convert")
+	}
+}
+
+func TestCachePutError(t *testing.T) {
+	fp := newFakePandoc(t)
+	dName := filepath.Join(t.TempDir(), "cache")
+	// A file where the cache directory should be makes every Put fail
+	if err := os.WriteFile(dName, nil, 0664); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	cfg := fp.config()
+	cfg.Cache = NewCache(NewDirCache(dName))
+	cfg.Verbose = true
+	if _, err := cfg.Convert(strings.NewReader("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "can't cache the result") {
+		t.Errorf("expected the failed Put to be logged, got %q", buf)
+	}
+}
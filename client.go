@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxIdleConns is used when Config.MaxIdleConns is not set.
+	DefaultMaxIdleConns = 16
+)
+
+var (
+	// defaultClients holds the clients shared by configurations that do
+	// not set Config.Client, keyed by clientSettings.
+	defaultClients sync.Map
+)
+
+// Client holds the http.Client used to send requests to pandoc-server.
+// A Client is safe for concurrent use and should be shared so that
+// connections to pandoc-server are reused.
+type Client struct {
+	// HTTPClient sends the requests, it can be replaced by the caller
+	// (e.g. to add tracing or a custom TLS configuration).
+	HTTPClient *http.Client
+}
+
+// ServerError is returned when pandoc-server answers a request with an
+// HTTP status other than 200 OK, e.g. when pandoc fails to convert a
+// document.
+type ServerError struct {
+	// Method and URL of the failed request
+	Method string
+	URL    string
+	// StatusCode is the HTTP status code returned
+	StatusCode int
+	// Message is the body of the response, usually pandoc's error message
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s %s failed, %d %s: %s", e.URL, e.Method, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// clientSettings are the configuration values used by NewClient.
+type clientSettings struct {
+	socket            string
+	timeout           int
+	maxIdleConns      int
+	disableKeepAlives bool
+}
+
+// NewClient returns a Client using the Timeout, MaxIdleConns and
+// DisableKeepAlives settings of the configuration. If ServerURL is a
+// unix domain socket then the client dials the socket.
+func NewClient(cfg *Config) *Client {
+	maxIdleConns := cfg.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = DefaultMaxIdleConns
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+	}
+	if socket := cfg.socketPath(); socket != "" {
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	return &Client{
+		HTTPClient: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+		},
+	}
+}
+
+// Do sends an HTTP request to pandoc-server and returns the response.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c.HTTPClient == nil {
+		return http.DefaultClient.Do(req)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// client returns the Client used to send requests to pandoc-server. If
+// Config.Client is not set a client with the same settings is shared
+// between configurations.
+func (cfg *Config) client() *Client {
+	if cfg.Client != nil {
+		return cfg.Client
+	}
+	key := clientSettings{
+		socket:            cfg.socketPath(),
+		timeout:           cfg.Timeout,
+		maxIdleConns:      cfg.MaxIdleConns,
+		disableKeepAlives: cfg.DisableKeepAlives,
+	}
+	if c, ok := defaultClients.Load(key); ok {
+		return c.(*Client)
+	}
+	c, _ := defaultClients.LoadOrStore(key, NewClient(cfg))
+	return c.(*Client)
+}
+
+// send makes a request to the named pandoc-server end point and returns
+// the body of the response. Responses other than 200 OK are returned as
+// a *ServerError.
+func (cfg *Config) send(ctx context.Context, method string, name string, query url.Values, accept string, body []byte) ([]byte, error) {
+	u, err := cfg.endpoint(name)
+	if err != nil {
+		return nil, err
+	}
+	target := u
+	if len(query) > 0 {
+		target = u + "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	// Execute the request
+	resp, err := cfg.client().Do(req)
+	if err != nil {
+		log.Printf("%s %s failed, %s", u, method, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Process response
+	src, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("%s %s read body failed, %s", u, method, err)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ServerError{
+			Method:     method,
+			URL:        u,
+			StatusCode: resp.StatusCode,
+			Message:    string(bytes.TrimSpace(src)),
+		}
+	}
+	return src, nil
+}
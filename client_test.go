@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	requests int32
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&ct.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientInjected(t *testing.T) {
+	fp := newFakePandoc(t)
+	cfg := fp.config()
+	ct := new(countingTransport)
+	cfg.Client = &Client{HTTPClient: &http.Client{Transport: ct}}
+	if _, err := cfg.Convert(bytes.NewReader([]byte("one"))); err != nil {
+		t.Error(err)
+	}
+	if _, err := cfg.ConvertBatch([]io.Reader{bytes.NewReader([]byte("two"))}); err != nil {
+		t.Error(err)
+	}
+	if _, err := cfg.ServerVersion(); err != nil {
+		t.Error(err)
+	}
+	if _, err := cfg.Babelmark("three", ""); err != nil {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&ct.requests); n != 4 {
+		t.Errorf("expected 4 requests using the injected client, got %d", n)
+	}
+}
+
+func TestClientShared(t *testing.T) {
+	cfg1 := &Config{Port: ":3030"}
+	cfg2 := &Config{ServerURL: "http://pandoc:3030"}
+	if cfg1.client() != cfg2.client() {
+		t.Errorf("expected configurations with the same settings to share a client")
+	}
+	cfg2.Timeout = 30
+	c := cfg2.client()
+	if cfg1.client() == c {
+		t.Errorf("expected a different client when the timeout differs")
+	}
+	if c.HTTPClient.Timeout.Seconds() != 30 {
+		t.Errorf("expected a 30 second timeout, got %s", c.HTTPClient.Timeout)
+	}
+	transport := c.HTTPClient.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != DefaultMaxIdleConns {
+		t.Errorf("expected %d idle connections per host, got %d", DefaultMaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+}
@@ -15,9 +15,15 @@ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
 
@@ -37,7 +43,8 @@ var (
 # SYNOPSIS
 
 ~~~
-{app_name} [OPTIONS] CONFIG_JSON HTDOCS
+{app_name} [OPTIONS] CONFIG_JSON HTDOCS [OUT_DIR]
+{app_name} -babelmark [-from FORMAT] [CONFIG_JSON] [INPUT_FILE]
 ~~~
 
 # DESCRIPTION
@@ -52,6 +59,102 @@ format the "POST" sent to the [Pandoc Server](https://pandoc.org/pandoc-server.h
 The HTDOCS directory path will be recusively walked to find
 files ending in ".md" and write successfull conversions to 
 the same file path using a ".html" extension instead of ".md".
+If ` + "`" + `OUT_DIR` + "`" + ` is given the HTML files are written there instead,
+mirroring the directory structure of HTDOCS. Directories are
+created as needed so HTDOCS can be read only.
+
+If the configuration has a "source_exts" array, e.g.
+` + "`" + `[".md", ".rst", ".org", ".docx"]` + "`" + `, the files ending in any of
+those extensions are converted instead of just ".md" files. Each
+file is read using the format its extension maps to, the built-in
+mapping can be extended with an "ext_types" object, e.g.
+` + "`" + `{".txt": "markdown"}` + "`" + `.
+
+Several files can be written for each source by listing output
+profiles in a "profiles" array. Each profile has a "name", the
+pandoc format it writes in "to", the extension of the files it
+writes in "ext" and optionally "options" which override the rest of
+the configuration. Each source is read once and converted for every
+profile, e.g.
+
+~~~
+"profiles": [
+	{"name": "web", "to": "html5", "ext": ".html"},
+	{"name": "print", "to": "latex", "ext": ".tex",
+	 "options": {"standalone": true}},
+	{"name": "word", "to": "docx", "ext": ".docx"},
+	{"name": "ebook", "to": "epub", "ext": ".epub"}
+]
+~~~
+
+Pandoc Server can't write PDF, it needs a TeX engine. Write "latex", as
+the "print" profile does, and run the ` + "`" + `.tex` + "`" + ` files through a TeX engine
+like pdflatex. A configuration asking for "pdf" is rejected.
+
+Files and directories can be skipped by listing patterns in the
+"exclude" array of the configuration or in a ` + "`" + `.pandocignore` + "`" + ` file at
+the top of HTDOCS. Patterns follow the ` + "`" + `.gitignore` + "`" + ` conventions, e.g.
+` + "`" + `drafts/` + "`" + `, ` + "`" + `README.md` + "`" + ` or ` + "`" + `posts/**/*.md` + "`" + `. If an "include" array is
+given only the files matching one of its patterns are converted.
+
+Each HTML file is written to a temporary file beside it and renamed
+into place, so a web server never sees a half written page. Files
+whose HTML hasn't changed are left untouched so their modification
+times stay the same. HTML files are created with the permissions
+"0664" less the umask, like any other file, unless the configuration
+sets "file_mode", e.g. ` + "`" + `"0644"` + "`" + `, which is applied as given. Without
+"file_mode" an HTML file which is rewritten keeps its permissions.
+
+{app_name} keeps a manifest of content hashes called ".pandoc-manifest.json"
+at the top of the output directory. Files whose Markdown and
+configuration haven't changed since the last run are skipped. Use
+the -force option to convert everything.
+
+{app_name} stops at the first file which fails to convert. With the
+` + "`" + `-continue-on-error` + "`" + ` option, or "continue_on_error" in the
+configuration, it logs each failure and carries on with the other
+files, then reports how many files failed. The exit code is only
+non-zero when at least one file failed.
+
+HTML files whose Markdown file has been deleted are left alone unless
+the ` + "`" + `-prune` + "`" + ` option is given, then they are removed once the other
+files are converted. Only the HTML files {app_name} recorded in its
+manifest, inside the output directory, are removed. Hand written HTML
+pages and HTML written by other tools are kept, whether the HTML is
+written beside the Markdown or to a separate directory. Use
+` + "`" + `-orphans` + "`" + ` to list the files ` + "`" + `-prune` + "`" + ` would remove without
+converting or removing anything.
+
+The ` + "`" + `-dry-run` + "`" + ` option shows which files would be converted, which
+would be skipped because they are up to date and where the HTML
+would be written, without contacting the Pandoc Server or writing
+anything. It uses the same configuration as a real run so the
+"include", "exclude" and extension settings apply. Add ` + "`" + `-json` + "`" + ` to
+get the plan as a JSON array instead of text, ` + "`" + `-json` + "`" + ` can only be used
+with ` + "`" + `-dry-run` + "`" + `. The exit code is non-zero when the plan has a file
+which can't be converted. With ` + "`" + `-watch` + "`" + ` the plan for each change is
+logged instead of converting the files, ` + "`" + `-json` + "`" + ` can't be used then.
+
+With the ` + "`" + `-watch` + "`" + ` option {app_name} keeps running after converting the
+files and checks HTDOCS for changes every second. Files which are
+created or modified are converted once they stop changing and the
+HTML files of deleted Markdown files are removed. Set "poll_interval"
+and "debounce" in the configuration, in milliseconds, to change how
+often it checks and how long it waits. A file which fails because
+the Pandoc Server is down or fails is tried again, waiting longer
+after each failure up to a minute, other failures wait for the file
+to be changed. Press Ctrl-C to stop.
+
+Before converting {app_name} asks the Pandoc Server for its version
+and stops with an error if the configuration uses options the
+server's version of Pandoc does not support.
+
+With the ` + "`" + `-babelmark` + "`" + ` option {app_name} sends a single document to the
+Pandoc Server's babelmark end point and writes the rendered HTML to
+standard output. This is handy for checking how a tricky Markdown
+fragment renders. The document is read from ` + "`" + `INPUT_FILE` + "`" + ` or
+standard input if no file is given. The ` + "`" + `CONFIG_JSON` + "`" + ` file is optional
+in this mode.
 
 # OPTIONS
 
@@ -67,6 +170,36 @@ the same file path using a ".html" extension instead of ".md".
 -verbose
 : use verbose log output
 
+-force
+: convert every file even if it hasn't changed since the last run
+
+-workers N
+: convert N files at the same time, overrides "workers" in CONFIG_JSON
+
+-continue-on-error
+: keep converting files after one fails and report the failures at the end
+
+-prune
+: remove HTML files whose Markdown file has been deleted
+
+-orphans
+: list the HTML files -prune would remove and exit
+
+-dry-run
+: show what would be converted without converting anything
+
+-json
+: show the -dry-run plan as JSON
+
+-watch
+: keep converting files as they change until interrupted
+
+-babelmark
+: render a single document using the babelmark end point
+
+-from FORMAT
+: the format of the document rendered with -babelmark, e.g. commonmark
+
 # EXAMPLE
 
 In this example we have markdown files in a directory structure
@@ -92,6 +225,18 @@ by the user running ` + "`" + `{app_name}` + "`" + `. As the Markdown files are
 a log message will be written indicating any errors or that the file
 was successful converted.
 
+To keep the Markdown sources apart from the published HTML
+
+~~~
+{app_name} config.json /var/www/src /var/www/htdocs
+~~~
+
+To see how a Markdown fragment renders using CommonMark
+
+~~~
+echo '*hello* _world_' | {app_name} -babelmark -from commonmark
+~~~
+
 `
 )
 
@@ -99,14 +244,100 @@ func usage(appName string) string {
 	return strings.ReplaceAll(helpText, "{app_name}", appName)
 }
 
+// runBabelmark renders a single document using the Pandoc Server's
+// babelmark end point and returns the exit code.
+func runBabelmark(args []string, from string, verbose bool) int {
+	cfg := &pandoc_client.Config{}
+	if len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "ERROR: expected an optional json configuration filename and input filename\n")
+		return 1
+	}
+	if len(args) == 2 {
+		var err error
+		cfg, err = pandoc_client.Load(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		args = args[1:]
+	}
+	cfg.Verbose = verbose
+	var (
+		src []byte
+		err error
+	)
+	if len(args) == 0 || args[0] == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	result, err := cfg.Babelmark(string(src), from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if verbose {
+		log.Printf("rendered with pandoc %s", result.Version)
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", result.HTML)
+	return 0
+}
+
+// runDryRun writes the plan for converting the files in htdocs to
+// standard output as text or JSON and returns the exit code, which is
+// non-zero if any file can't be converted.
+func runDryRun(cfg *pandoc_client.Config, htdocs string, fromExt string, asJSON bool) int {
+	steps, err := cfg.Plan(htdocs, fromExt, ".html")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if asJSON {
+		src, err := json.MarshalIndent(steps, "", "    ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", src)
+	} else {
+		for _, step := range steps {
+			fmt.Fprintf(os.Stdout, "%s\n", step)
+		}
+	}
+	for _, step := range steps {
+		if step.Action == "error" {
+			return 1
+		}
+	}
+	return 0
+}
+
 func main() {
 	appName := path.Base(os.Args[0])
 	showHelp, showVersion, showLicense := false, false, false
-	verbose := false
+	verbose, babelmark, force, watch := false, false, false, false
+	prune, orphans, dryRun, asJSON := false, false, false, false
+	continueOnError := false
+	from := ""
+	workers := 0
 	flag.BoolVar(&showHelp, "help", showHelp, "display help")
 	flag.BoolVar(&showVersion, "version", showVersion, "display version")
 	flag.BoolVar(&showLicense, "license", showLicense, "display license")
 	flag.BoolVar(&verbose, "verbose", verbose, "verbose log output")
+	flag.BoolVar(&force, "force", force, "convert every file even if unchanged")
+	flag.IntVar(&workers, "workers", workers, "number of files to convert at the same time")
+	flag.BoolVar(&watch, "watch", watch, "keep converting files as they change")
+	flag.BoolVar(&continueOnError, "continue-on-error", continueOnError, "keep converting files after one fails")
+	flag.BoolVar(&prune, "prune", prune, "remove HTML files whose Markdown file is gone")
+	flag.BoolVar(&orphans, "orphans", orphans, "list HTML files whose Markdown file is gone")
+	flag.BoolVar(&dryRun, "dry-run", dryRun, "show what would be converted without converting anything")
+	flag.BoolVar(&asJSON, "json", asJSON, "show the -dry-run plan as JSON")
+	flag.BoolVar(&babelmark, "babelmark", babelmark, "render a single document using the babelmark end point")
+	flag.StringVar(&from, "from", from, "format of the document rendered with -babelmark")
 	flag.Parse()
 
 	if showHelp {
@@ -123,9 +354,16 @@ func main() {
 		os.Exit(0)
 	}
 
+	if asJSON && (!dryRun || watch) {
+		fmt.Fprintf(os.Stderr, "ERROR: -json can only be used with -dry-run and not with -watch\n")
+		os.Exit(1)
+	}
 	args := flag.Args()
-	if len(args) != 2 {
-		fmt.Fprintf(os.Stderr, "ERROR: expected a json configuration filename and htdocs path\n")
+	if babelmark {
+		os.Exit(runBabelmark(args, from, verbose))
+	}
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Fprintf(os.Stderr, "ERROR: expected a json configuration filename, htdocs path and optional output path\n")
 		os.Exit(1)
 	}
 	cfg, err := pandoc_client.Load(args[0])
@@ -134,9 +372,66 @@ func main() {
 		os.Exit(1)
 	}
 	cfg.Verbose = verbose
+	if workers > 0 {
+		cfg.Workers = workers
+	}
+	cfg.SkipUnchanged = true
+	cfg.Force = force
+	if prune {
+		cfg.Prune = true
+	}
+	if continueOnError {
+		cfg.ContinueOnError = true
+	}
+	if len(args) == 3 {
+		cfg.OutputDir = args[2]
+	}
+	fromExt := ".md"
+	if len(cfg.SourceExts) > 0 {
+		fromExt = ""
+	}
+	if orphans {
+		fNames, err := cfg.Orphans(args[1], fromExt, ".html")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		for _, fName := range fNames {
+			fmt.Fprintf(os.Stdout, "%s\n", fName)
+		}
+		os.Exit(0)
+	}
+	// NOTE: md2html always writes HTML from Markdown so the formats are
+	// set before the configuration is checked.
 	cfg.From = "markdown"
 	cfg.To = "html5"
-	if err := cfg.Walk(args[1], ".md", ".html"); err != nil {
+	if dryRun && !watch {
+		os.Exit(runDryRun(cfg, args[1], fromExt, asJSON))
+	}
+	if dryRun {
+		// NOTE: Watch logs the plan for each change instead of
+		// converting so pandoc-server isn't needed.
+		cfg.DryRun = true
+	} else if err := cfg.Check(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	// Stop cleanly on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if watch {
+		err = cfg.Watch(ctx, args[1], fromExt, ".html")
+	} else {
+		err = cfg.WalkContext(ctx, args[1], fromExt, ".html")
+	}
+	// NOTE: Each failure has already been logged so only the summary
+	// is needed.
+	var report *pandoc_client.WalkErrors
+	if errors.As(err, &report) {
+		fmt.Fprintf(os.Stderr, "%s\n", report.Summary())
+		os.Exit(1)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The stages of converting a file a FileError can come from.
+const (
+	StageRead      = "read"
+	StageResources = "resources"
+	StagePrepare   = "prepare"
+	StageConvert   = "convert"
+	StageWrite     = "write"
+)
+
+// stageVerbs describes each stage in error messages.
+var stageVerbs = map[string]string{
+	StageRead:      "reading",
+	StageResources: "collecting resources for",
+	StagePrepare:   "preparing",
+	StageConvert:   "converting",
+	StageWrite:     "writing",
+}
+
+// FileError describes a file Walk failed to convert.
+type FileError struct {
+	// Path is the source file
+	Path string `json:"path"`
+	// Output is the file being written, if the failure was for one of
+	// the file's outputs
+	Output string `json:"output,omitempty"`
+	// Stage is where the conversion failed, e.g. StageConvert
+	Stage string `json:"stage"`
+	// Message is pandoc's error message when pandoc-server failed to
+	// convert the file
+	Message string `json:"message,omitempty"`
+	// StatusCode is the HTTP status pandoc-server answered with, zero if
+	// the request wasn't answered
+	StatusCode int `json:"status_code,omitempty"`
+	// Err is the underlying error
+	Err error `json:"-"`
+}
+
+// newFileError describes err as a failure of path, the pandoc message
+// and HTTP status are filled in from a *ServerError.
+func newFileError(stage string, path string, output string, err error) *FileError {
+	e := &FileError{Path: path, Output: output, Stage: stage, Err: err}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		e.Message = serverErr.Message
+		e.StatusCode = serverErr.StatusCode
+	}
+	return e
+}
+
+func (e *FileError) Error() string {
+	verb, ok := stageVerbs[e.Stage]
+	if !ok {
+		verb = e.Stage
+	}
+	if e.Stage == StageWrite && e.Output != "" {
+		return fmt.Sprintf("%s %q: %s", verb, e.Output, e.Err)
+	}
+	return fmt.Sprintf("%s %q: %s", verb, e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// WalkErrors is returned by Walk when ContinueOnError is set and at
+// least one file failed to convert.
+type WalkErrors struct {
+	// Files is the number of files the walk converted or tried to
+	Files int `json:"files"`
+	// Errors holds the failures in walk order
+	Errors []*FileError `json:"errors"`
+}
+
+// Failed returns the number of files which failed, a file converted
+// for several profiles is counted once.
+func (e *WalkErrors) Failed() int {
+	paths := map[string]bool{}
+	for _, err := range e.Errors {
+		paths[err.Path] = true
+	}
+	return len(paths)
+}
+
+// Summary returns a single line describing how many files failed.
+func (e *WalkErrors) Summary() string {
+	return fmt.Sprintf("%d of %d files failed to convert", e.Failed(), e.Files)
+}
+
+// Error returns the summary followed by each failure on a line of its own.
+func (e *WalkErrors) Error() string {
+	lines := []string{e.Summary()}
+	for _, err := range e.Errors {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns the failures so errors.Is and errors.As can look at
+// each of them.
+func (e *WalkErrors) Unwrap() []error {
+	errs := []error{}
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+)
+
+// Files maps the paths of the resources a document uses (images,
+// bibliographies, CSL styles, reference docs) to their base64 encoded
+// contents. pandoc-server can't read the file system so these are sent
+// along with the document.
+type Files map[string]string
+
+// Add reads r and stores it under name. The name should be the path
+// used to refer to the file in the document or configuration,
+// e.g. "images/logo.png" or "references.bib".
+func (f Files) Add(name string, r io.Reader) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f[path.Clean(filepath.ToSlash(name))] = base64.StdEncoding.EncodeToString(src)
+	return nil
+}
+
+// AddFS adds the files in fsys which match the pattern, see fs.Glob for
+// the syntax. Each file is stored under its path in fsys.
+func (f Files) AddFS(fsys fs.FS, pattern string) error {
+	matches, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no files match %q", pattern)
+	}
+	for _, name := range matches {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		src, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		f[name] = base64.StdEncoding.EncodeToString(src)
+	}
+	return nil
+}
+
+// Has returns true if a file is stored under name.
+func (f Files) Has(name string) bool {
+	_, ok := f[path.Clean(filepath.ToSlash(name))]
+	return ok
+}
+
+// AddFile reads r and adds it to the files sent with each request
+// under name, see Files.Add.
+//
+// ```
+//
+//	cfg.Bibliography = []string{"references.bib"}
+//	src, err := os.Open("htdocs/references.bib")
+//	// ... handle error
+//	defer src.Close()
+//	if err := cfg.AddFile("references.bib", src); err != nil {
+//		// ... handle error
+//	}
+//
+// ```
+func (cfg *Config) AddFile(name string, r io.Reader) error {
+	if cfg.Files == nil {
+		cfg.Files = Files{}
+	}
+	return cfg.Files.Add(name, r)
+}
+
+// AddFS adds the files in fsys matching pattern to the files sent with
+// each request, see Files.AddFS.
+//
+// ```
+//
+//	err := cfg.AddFS(os.DirFS("htdocs"), "images/*.png")
+//
+// ```
+func (cfg *Config) AddFS(fsys fs.FS, pattern string) error {
+	if cfg.Files == nil {
+		cfg.Files = Files{}
+	}
+	return cfg.Files.AddFS(fsys, pattern)
+}
+
+// MissingFiles returns the file paths named in the configuration (e.g.
+// bibliography, csl, reference-doc) which are not in Files and so can't
+// be found by pandoc-server.
+func (cfg *Config) MissingFiles() []string {
+	names := append([]string{}, cfg.Bibliography...)
+	names = append(names, cfg.Csl, cfg.ReferenceDoc, cfg.EPubCoverImage, cfg.EPubMetadata, cfg.EPubFonts)
+	missing := []string{}
+	for _, name := range names {
+		if name != "" && !cfg.Files.Has(name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
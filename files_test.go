@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"images/logo.png": {Data: []byte("PNG")},
+		"images/icon.png": {Data: []byte("ICON")},
+		"references.bib":  {Data: []byte("@book{doe2022}")},
+	}
+	cfg := &Config{
+		Bibliography: []string{"references.bib"},
+		Csl:          "chicago.csl",
+	}
+	if err := cfg.AddFS(fsys, "images/*.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.AddFile("./references.bib", strings.NewReader("@book{doe2022}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.AddFS(fsys, "*.csl"); err == nil {
+		t.Errorf("expected an error when no files match")
+	}
+	missing := cfg.MissingFiles()
+	if len(missing) != 1 || missing[0] != "chicago.csl" {
+		t.Errorf("expected chicago.csl to be missing, got %v", missing)
+	}
+
+	src, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := struct {
+		Files map[string]string `json:"files"`
+	}{}
+	if err := json.Unmarshal(src, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"images/logo.png": "UE5H",
+		"images/icon.png": "SUNPTg==",
+		"references.bib":  "QGJvb2t7ZG9lMjAyMn0=",
+	} {
+		if doc.Files[name] != expected {
+			t.Errorf("expected files[%q] to be %q, got %q", name, expected, doc.Files[name])
+		}
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PandocIgnoreName is the name of the optional file at the top of the
+// tree Walk reads exclude patterns from. It uses the same pattern syntax
+// as a .gitignore file.
+const PandocIgnoreName = ".pandocignore"
+
+// globPattern is a compiled include or exclude pattern. Patterns follow
+// .gitignore conventions, a pattern without a slash matches a name at
+// any depth, a pattern with a slash is relative to the top of the walk,
+// a trailing slash only matches directories, "**" matches any number of
+// directories and a leading "!" re-includes something an earlier
+// pattern excluded.
+type globPattern struct {
+	negate  bool
+	dirOnly bool
+	parts   []string
+}
+
+// compileGlob parses a single pattern.
+func compileGlob(s string) (*globPattern, error) {
+	p := &globPattern{}
+	pattern := s
+	if strings.HasPrefix(pattern, "!") {
+		p.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		p.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("%q is not a valid pattern", s)
+	}
+	// NOTE: Like .gitignore a pattern without a slash can match at any depth.
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	for _, part := range strings.Split(strings.TrimPrefix(pattern, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("%q is not a valid pattern, %s", s, err)
+		}
+		p.parts = append(p.parts, part)
+	}
+	return p, nil
+}
+
+// compileGlobs parses a list of patterns.
+func compileGlobs(patterns []string) ([]*globPattern, error) {
+	globs := []*globPattern{}
+	for _, s := range patterns {
+		p, err := compileGlob(s)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, p)
+	}
+	return globs, nil
+}
+
+// match reports if the slash separated path rel, relative to the top of
+// the walk, matches the pattern.
+func (p *globPattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchParts(p.parts, strings.Split(rel, "/"))
+}
+
+// matchParts matches pattern parts against path parts, "**" matching
+// zero or more path parts.
+func matchParts(parts []string, names []string) bool {
+	for len(parts) > 0 {
+		if parts[0] == "**" {
+			for i := 0; i <= len(names); i++ {
+				if matchParts(parts[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(parts[0], names[0]); !ok {
+			return false
+		}
+		parts, names = parts[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// readPandocIgnore reads the patterns in a .pandocignore file skipping
+// blank lines and comments. A missing file has no patterns.
+func readPandocIgnore(fName string) ([]string, error) {
+	fp, err := os.Open(fName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fp.Close()
+	patterns := []string{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// walkFilter decides which files and directories Walk looks at.
+type walkFilter struct {
+	include []*globPattern
+	exclude []*globPattern
+}
+
+// newWalkFilter compiles the Include and Exclude patterns along with
+// those in the .pandocignore file at the top of startPath.
+func (cfg *Config) newWalkFilter(startPath string) (*walkFilter, error) {
+	include, err := compileGlobs(cfg.Include)
+	if err != nil {
+		return nil, fmt.Errorf("include: %s", err)
+	}
+	exclude, err := compileGlobs(cfg.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %s", err)
+	}
+	ignoreName := filepath.Join(startPath, PandocIgnoreName)
+	patterns, err := readPandocIgnore(ignoreName)
+	if err != nil {
+		return nil, err
+	}
+	ignore, err := compileGlobs(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ignoreName, err)
+	}
+	return &walkFilter{include: include, exclude: append(exclude, ignore...)}, nil
+}
+
+// excluded reports if a file or directory, relative to the top of the
+// walk, is excluded. The last matching pattern wins so a "!" pattern can
+// re-include what an earlier one excluded.
+func (f *walkFilter) excluded(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	excluded := false
+	for _, p := range f.exclude {
+		if p.match(rel, isDir) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+// included reports if a file, relative to the top of the walk, matches
+// the include patterns. Every file is included if there are none.
+func (f *walkFilter) included(rel string) bool {
+	if len(f.include) == 0 {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+	included := false
+	for _, p := range f.include {
+		if p.match(rel, false) {
+			included = !p.negate
+		}
+	}
+	return included
+}
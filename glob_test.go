@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGlobPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		match   bool
+	}{
+		{"README.md", "README.md", false, true},
+		{"README.md", "docs/README.md", false, true},
+		{"/README.md", "docs/README.md", false, false},
+		{"drafts/", "drafts", true, true},
+		{"drafts/", "blog/drafts", true, true},
+		{"drafts/", "drafts", false, false},
+		{"*.md", "a/b/c.md", false, true},
+		{"docs/*.md", "docs/a.md", false, true},
+		{"docs/*.md", "docs/a/b.md", false, false},
+		{"docs/**/*.md", "docs/a.md", false, true},
+		{"docs/**/*.md", "docs/a/b/c.md", false, true},
+		{"docs/**/*.md", "other/docs/a.md", false, false},
+		{"**/node_modules/", "a/node_modules", true, true},
+		{"a/**", "a/b/c", false, true},
+	}
+	for _, test := range tests {
+		p, err := compileGlob(test.pattern)
+		if err != nil {
+			t.Errorf("%q: %s", test.pattern, err)
+			continue
+		}
+		if match := p.match(test.rel, test.isDir); match != test.match {
+			t.Errorf("%q matching %q (dir %t): expected %t, got %t", test.pattern, test.rel, test.isDir, test.match, match)
+		}
+	}
+	for _, pattern := range []string{"", "!", "[a-"} {
+		if _, err := compileGlob(pattern); err == nil {
+			t.Errorf("expected an error for %q", pattern)
+		}
+	}
+}
+
+func TestWalkIncludeExclude(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"index.md":                  "home",
+		"README.md":                 "readme",
+		"drafts/next.md":            "draft",
+		"node_modules/pkg/notes.md": "package",
+		"blog/post.md":              "post",
+		"blog/README.md":            "blog readme",
+		"blog/keep/README.md":       "kept",
+		"blog/old/post.md":          "old post",
+		".pandocignore": `# not published
+drafts/
+node_modules/
+README.md
+!blog/keep/README.md
+`,
+	})
+	cfg := fp.config()
+	cfg.Exclude = []string{"blog/old/"}
+	cfg.Include = []string{"*.md"}
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	converted := []string{}
+	filepath.Walk(root, func(fName string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(fName, ".html") {
+			rel, _ := filepath.Rel(root, fName)
+			converted = append(converted, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(converted)
+	expected := "blog/keep/README.html blog/post.html index.html"
+	if got := strings.Join(converted, " "); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	cfg.Include = []string{"blog/*.md"}
+	cfg.Exclude = nil
+	cfg.OutputDir = filepath.Join(root, "out")
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "out/blog/post.html")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "out/index.html")); err == nil {
+		t.Errorf("index.md does not match the include pattern")
+	}
+}
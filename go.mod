@@ -1,3 +1,3 @@
 module github.com/caltechlibrary/pandoc_client
 
-go 1.19
+go 1.20
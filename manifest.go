@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// ManifestName is the file Walk keeps at the top of the output tree
+	// when SkipUnchanged is set. It records the source and configuration
+	// each output was converted from.
+	ManifestName = ".pandoc-manifest.json"
+)
+
+// manifestEntry records how an output file was made.
+type manifestEntry struct {
+	// Source is the path of the source file relative to the start path
+	Source string `json:"source"`
+	// SourceHash is the SHA-256 of the source file's contents
+	SourceHash string `json:"source_hash"`
+	// ConfigHash is the SHA-256 of the request sent without the text,
+	// it covers the pandoc options and any resources sent
+	ConfigHash string `json:"config_hash"`
+}
+
+// manifest maps output paths, relative to the output tree, to how they
+// were made. It is safe for concurrent use.
+type manifest struct {
+	mu      sync.Mutex
+	fName   string
+	Outputs map[string]*manifestEntry `json:"outputs"`
+}
+
+// loadManifest reads the manifest in dName. A missing or unreadable
+// manifest is treated as empty so everything is converted.
+func loadManifest(dName string) *manifest {
+	m := &manifest{
+		fName:   filepath.Join(dName, ManifestName),
+		Outputs: map[string]*manifestEntry{},
+	}
+	if src, err := os.ReadFile(m.fName); err == nil {
+		if err := json.Unmarshal(src, m); err != nil || m.Outputs == nil {
+			m.Outputs = map[string]*manifestEntry{}
+		}
+	}
+	return m
+}
+
+// unchanged returns true if output was made from the same source and
+// configuration as entry.
+func (m *manifest) unchanged(output string, entry *manifestEntry) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev, ok := m.Outputs[output]
+	return ok && *prev == *entry
+}
+
+// set records how output was made.
+func (m *manifest) set(output string, entry *manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Outputs[output] = entry
+}
+
+// remove forgets output, e.g. when its conversion failed.
+func (m *manifest) remove(output string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Outputs, output)
+}
+
+// save writes the manifest back to the output tree.
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = writeFileAtomic(m.fName, src, 0)
+	return err
+}
+
+// hashOf returns the hex encoded SHA-256 of src.
+func hashOf(src []byte) string {
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:])
+}
+
+// newManifestEntry describes the conversion of src from fName, relative
+// to startPath, using req.
+func newManifestEntry(startPath string, fName string, src []byte, req *request) (*manifestEntry, error) {
+	rel, err := filepath.Rel(startPath, fName)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: The text is left out so the hash only changes with the
+	// configuration and resources.
+	opts := *req
+	opts.Text = ""
+	cfgSrc, err := json.Marshal(&opts)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestEntry{
+		Source:     filepath.ToSlash(rel),
+		SourceHash: hashOf(src),
+		ConfigHash: hashOf(cfgSrc),
+	}, nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.md":     "one",
+		"b/b.md":   "two",
+		"b/c/c.md": "three",
+	})
+	cfg := fp.config()
+	cfg.SkipUnchanged = true
+	walk := func(expected int32) {
+		t.Helper()
+		before := atomic.LoadInt32(&fp.converts)
+		if err := cfg.Walk(root, ".md", ".html"); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&fp.converts) - before; n != expected {
+			t.Errorf("expected %d conversions, got %d", expected, n)
+		}
+	}
+	walk(3)
+	if _, err := os.Stat(filepath.Join(root, ManifestName)); err != nil {
+		t.Fatal(err)
+	}
+	walk(0)
+
+	// Changing a source only converts that file
+	writeFiles(t, root, map[string]string{"b/b.md": "two, again"})
+	walk(1)
+
+	// Removing an output converts it again
+	if err := os.Remove(filepath.Join(root, "a.html")); err != nil {
+		t.Fatal(err)
+	}
+	walk(1)
+
+	// Changing the configuration converts everything
+	cfg.Standalone = true
+	walk(3)
+	walk(0)
+
+	// Options which don't change the output don't matter
+	cfg.Workers = 2
+	walk(0)
+
+	cfg.Force = true
+	walk(3)
+}
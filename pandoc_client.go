@@ -15,22 +15,26 @@ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
 package pandoc_client
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
-	"path"
-	"path/filepath"
 	"strings"
 )
 
+// Config holds the pandoc options sent to pandoc-server along with the
+// settings of this package. Fields tagged `client:"true"` are settings
+// of this package and are never sent to pandoc-server.
 type Config struct {
 	// Port defaults to 3030, it is the port number that pandoc-server listens on
-	Port string `json:"port,omitempty"`
+	Port string `json:"port,omitempty" client:"true"`
+	// ServerURL is the base URL of pandoc-server, e.g. "https://example.edu/pandoc/",
+	// or the path to a unix domain socket, e.g. "unix:///run/pandoc.sock".
+	// When set it is used instead of Port.
+	ServerURL string `json:"server_url,omitempty" client:"true"`
 	// From is the doc type you are converting from, e.g. markdown
 	From string `json:"from,omitempty"`
 	// To is the doc type you are converting to, e.g. html5
@@ -49,7 +53,7 @@ type Config struct {
 	Text                  string                 `json:"text,omitempty"`
 	Template              string                 `json:"template,omitempty"`
 	Variables             map[string]interface{} `json:"variables,omitempty"`
-	DPI                   int                    `json:"dpi,omitemtpy"`
+	DPI                   int                    `json:"dpi,omitempty"`
 	Wrap                  string                 `json:"wrap,omitempty"`
 	Columns               int                    `json:"columns,omitempty"`
 	TableOfContents       bool                   `json:"table-of-contents,omitempty"`
@@ -84,13 +88,97 @@ type Config struct {
 	Bibliography          []string               `json:"bibliography,omitempty"`
 	Csl                   string                 `json:"csl,omitempty"`
 	CiteMethod            string                 `json:"cite-method,omitempty"`
-	Files                 []string               `json:files,omitempty"`
+	Files                 Files                  `json:"files,omitempty"`
 
 	// Verbose if set true then include logging on success as well as error
-	Verbose bool
+	Verbose bool `client:"true"`
 
-	// ExtTypes holds a mapping of extension to file type, e.d. ".html" to "html5"
-	//ExtTypes map[string]string `json:"ext-types,omitempty"`
+	// Timeout is the number of seconds to wait for each response from
+	// pandoc-server, zero means no time limit.
+	Timeout int `json:"timeout,omitempty" client:"true"`
+	// MaxIdleConns is the number of idle keep-alive connections kept open
+	// to pandoc-server, defaults to 16.
+	MaxIdleConns int `json:"max_idle_conns,omitempty" client:"true"`
+	// DisableKeepAlives turns off reusing connections to pandoc-server.
+	DisableKeepAlives bool `json:"disable_keep_alives,omitempty" client:"true"`
+	// Client sends the requests to pandoc-server. If nil a client built
+	// by NewClient with the settings above is shared between configurations.
+	Client *Client `json:"-"`
+
+	// CollectResources if true Walk sends the local images, bibliographies,
+	// CSL styles and reference docs a document refers to along with it,
+	// see CollectFiles.
+	CollectResources bool `json:"collect_resources,omitempty" client:"true"`
+	// MaxResourceBytes limits the total size of the resources collected for
+	// a document, defaults to 16 MiB.
+	MaxResourceBytes int64 `json:"max_resource_bytes,omitempty" client:"true"`
+
+	// OutputDir if set is where Walk writes the converted files, the
+	// directory structure of the source tree is mirrored there.
+	OutputDir string `json:"output_dir,omitempty" client:"true"`
+	// Include if set limits Walk to the files matching one of these
+	// patterns, e.g. "posts/**/*.md". Patterns use .gitignore syntax.
+	Include []string `json:"include,omitempty" client:"true"`
+	// Exclude lists patterns for the files and directories Walk skips,
+	// e.g. "drafts/", ".git/" or "README.md". Patterns use .gitignore
+	// syntax, see also PandocIgnoreName.
+	Exclude []string `json:"exclude,omitempty" client:"true"`
+	// FileMode is the permissions of the files Walk writes as an octal
+	// string, e.g. "0644", defaults to DefaultFileMode less the umask.
+	FileMode string `json:"file_mode,omitempty" client:"true"`
+	// Workers is the number of files Walk converts at the same time,
+	// defaults to one.
+	Workers int `json:"workers,omitempty" client:"true"`
+
+	// Prune if true Walk removes the outputs whose source file has been
+	// deleted, only outputs recorded in the manifest are removed so Walk
+	// returns an error unless SkipUnchanged is set, see Orphans.
+	Prune bool `json:"prune,omitempty" client:"true"`
+
+	// ContinueOnError if true Walk carries on converting files after one
+	// fails and returns the failures as a *WalkErrors.
+	ContinueOnError bool `json:"continue_on_error,omitempty" client:"true"`
+
+	// DryRun if true Walk and Watch log what they would do without
+	// contacting pandoc-server or writing anything, see Plan.
+	DryRun bool `json:"dry_run,omitempty" client:"true"`
+
+	// PollInterval is how often, in milliseconds, Watch looks for changed
+	// files, defaults to DefaultPollInterval.
+	PollInterval int `json:"poll_interval,omitempty" client:"true"`
+	// Debounce is how long, in milliseconds, files must stop changing
+	// before Watch converts them, defaults to DefaultDebounce.
+	Debounce int `json:"debounce,omitempty" client:"true"`
+
+	// SkipUnchanged if true Walk keeps a manifest of content hashes at the
+	// top of the output tree and skips files whose source and configuration
+	// haven't changed since they were last converted.
+	SkipUnchanged bool `json:"skip_unchanged,omitempty" client:"true"`
+	// Force if true Walk converts every file, the manifest is still updated.
+	Force bool `json:"force,omitempty" client:"true"`
+
+	// Cache if set holds the results of earlier conversions, see Cache.
+	Cache *Cache `json:"-"`
+	// CacheDir if set Load uses a Cache storing results in this directory.
+	CacheDir string `json:"cache_dir,omitempty" client:"true"`
+
+	// BatchSize is the maximum number of documents sent in a single POST
+	// to the /batch end point, defaults to 100.
+	BatchSize int `json:"batch_size,omitempty" client:"true"`
+	// BatchBytes is the maximum size in bytes of a single POST to the /batch
+	// end point, defaults to 8 MiB. A document larger than this is sent
+	// in a batch by itself.
+	BatchBytes int `json:"batch_bytes,omitempty" client:"true"`
+
+	// ExtTypes holds a mapping of extension to file type, e.d. ".html" to "html5".
+	// Load adds the mappings in DefaultExtTypes which are not set.
+	ExtTypes map[string]string `json:"ext_types,omitempty" client:"true"`
+	// SourceExts lists the extensions Walk converts when it is not given
+	// one, defaults to DefaultSourceExts.
+	SourceExts []string `json:"source_exts,omitempty" client:"true"`
+	// Profiles if set are the outputs Walk produces for each source file
+	// instead of the single one it is asked for, see Profile.
+	Profiles []*Profile `json:"profiles,omitempty" client:"true"`
 }
 
 var (
@@ -98,11 +186,80 @@ var (
 	// Pandoc options to be set based on file extension. This can be overwritten by setting
 	// `.ext_types` in the JSON configuraiton file.
 	DefaultExtTypes = map[string]string{
-		".md":   "markdown",
-		".html": "html5",
+		".md":       "markdown",
+		".markdown": "markdown",
+		".mdown":    "markdown",
+		".rst":      "rst",
+		".org":      "org",
+		".tex":      "latex",
+		".latex":    "latex",
+		".ltx":      "latex",
+		".ipynb":    "ipynb",
+		".html":     "html5",
+		".htm":      "html5",
+		".xhtml":    "html5",
+		".docx":     "docx",
+		".odt":      "odt",
+		".epub":     "epub",
+		".pptx":     "pptx",
+		".rtf":      "rtf",
+		".textile":  "textile",
+		".wiki":     "mediawiki",
+		".muse":     "muse",
+		".t2t":      "t2t",
+		".dbk":      "docbook",
+		".fb2":      "fb2",
+		".opml":     "opml",
+		".bib":      "bibtex",
+		".ris":      "ris",
+		".csv":      "csv",
+		".tsv":      "tsv",
+		".typ":      "typst",
+		".txt":      "markdown",
+		".1":        "man",
 	}
+
+	// DefaultSourceExts lists the extensions Walk converts when neither
+	// it nor SourceExts says otherwise.
+	DefaultSourceExts = []string{".md", ".markdown", ".rst", ".org", ".tex", ".ipynb", ".docx"}
 )
 
+// binaryFormats lists the pandoc formats which are zip containers rather
+// than text. NOTE: pandoc-server can neither read nor write pdf.
+var binaryFormats = []string{"docx", "odt", "epub", "epub2", "epub3", "pptx"}
+
+// isBinaryFormat returns true if the pandoc format is binary, extensions
+// like "+styles" are ignored.
+func isBinaryFormat(format string) bool {
+	return inStringList(baseFormat(format), binaryFormats)
+}
+
+// checkOutputFormat returns an error if pandoc-server can't write the
+// format. PDF needs a TeX engine so only the LaTeX can be asked for.
+func checkOutputFormat(format string) error {
+	if baseFormat(format) == "pdf" {
+		return fmt.Errorf("pandoc-server can't write pdf, write \"latex\" and run it through a TeX engine like pdflatex")
+	}
+	return nil
+}
+
+// extType returns the pandoc format for a file extension using ExtTypes
+// then DefaultExtTypes.
+func (cfg *Config) extType(ext string) (string, bool) {
+	// NOTE: Extensions are matched without regard to case, ".MD" is ".md".
+	ext = strings.ToLower(ext)
+	if format, ok := cfg.ExtTypes[ext]; ok && format != "" {
+		return format, true
+	}
+	for key, format := range cfg.ExtTypes {
+		if strings.ToLower(key) == ext && format != "" {
+			return format, true
+		}
+	}
+	format, ok := DefaultExtTypes[ext]
+	return format, ok
+}
+
 func inStringList(val string, list []string) bool {
 	for _, expected := range list {
 		if val == expected {
@@ -128,7 +285,29 @@ func Load(fName string) (*Config, error) {
 	} else if !strings.HasPrefix(cfg.Port, ":") {
 		cfg.Port = fmt.Sprintf(":%s", cfg.Port)
 	}
+	if cfg.ServerURL != "" {
+		if _, err := parseServerURL(cfg.ServerURL); err != nil {
+			return cfg, fmt.Errorf("server_url: %s", err)
+		}
+	}
 
+	if _, err := cfg.fileMode(); err != nil {
+		return cfg, err
+	}
+	if _, err := compileGlobs(cfg.Include); err != nil {
+		return cfg, fmt.Errorf("include: %s", err)
+	}
+	if _, err := compileGlobs(cfg.Exclude); err != nil {
+		return cfg, fmt.Errorf("exclude: %s", err)
+	}
+
+	if cfg.CacheDir != "" {
+		cfg.Cache = NewCache(NewDirCache(cfg.CacheDir))
+	}
+
+	if err := checkOutputFormat(cfg.To); err != nil {
+		return cfg, fmt.Errorf("to: %s", err)
+	}
 	if !inStringList(cfg.TrackChanges, []string{"accept", "reject", "all", ""}) {
 		return cfg, fmt.Errorf("tract-changes: %q is not supported", cfg.TrackChanges)
 	}
@@ -156,71 +335,121 @@ func Load(fName string) (*Config, error) {
 	if !inStringList(cfg.CiteMethod, []string{"citeproc", "natbib", "biblatex", ""}) {
 		return cfg, fmt.Errorf("cite-method: %q is not supported", cfg.CiteMethod)
 	}
-	/*
-		// Make sure we have the extension mappings for document type
-		if cfg.ExtTypes == nil {
-			cfg.ExtTypes = map[string]string{}
+	// Make sure we have the extension mappings for document type
+	extTypes := map[string]string{}
+	for k, v := range cfg.ExtTypes {
+		extTypes[strings.ToLower(k)] = v
+	}
+	cfg.ExtTypes = extTypes
+	for k, v := range DefaultExtTypes {
+		if _, ok := cfg.ExtTypes[k]; !ok {
+			cfg.ExtTypes[k] = v
 		}
-		for k, v := range DefaultExtTypes {
-			if _, ok := cfg.ExtTypes[k]; !ok {
-				cfg.ExtTypes[k] = v
-			}
+	}
+	if err := cfg.checkProfiles(""); err != nil {
+		return cfg, fmt.Errorf("profiles: %s", err)
+	}
+	for _, ext := range cfg.SourceExts {
+		if _, ok := cfg.extType(ext); !ok {
+			return cfg, fmt.Errorf("source_exts: %q is not in ext_types", ext)
 		}
-	*/
+	}
 	return cfg, nil
 }
 
-// RootEndpoint takes content type and sends the request to the Pandoc Server
-// Root end point based on the state of configuration struct used.
-func (cfg *Config) RootEndpoint() ([]byte, error) {
-	// NOTE: Pandoc Server API want JSON in POST not urlencoded form data
-	if cfg.Text == "" {
-		return nil, fmt.Errorf("expected to have a source text to convert, %+v", cfg)
-	}
-	src, err := json.Marshal(cfg)
+// parseServerURL parses and validates the base URL of pandoc-server.
+func parseServerURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
 	if err != nil {
 		return nil, err
 	}
-	if len(src) == 0 {
-		log.Printf("Nothing to convert")
-		return nil, fmt.Errorf("nothing to convert")
+	if u.Scheme == "unix" {
+		if u.Host != "" || u.Path == "" {
+			return nil, fmt.Errorf("%q should be in the form unix:///path/to/socket", s)
+		}
+		return u, nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%q must use http, https or unix", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q is missing a host", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("%q should not include a query or fragment", s)
 	}
-	// Setup out our JSON post request.
-	u := fmt.Sprintf("http://localhost%s/", cfg.Port)
-	body := bytes.NewReader(src)
-	req, err := http.NewRequest("POST", u, body)
+	return u, nil
+}
+
+// endpoint returns the URL of the named pandoc-server end point, e.g. "/"
+// or "/batch". If ServerURL is not set the server is expected on
+// localhost at Port. For unix domain sockets the host is always localhost
+// and the Client dials the socket.
+func (cfg *Config) endpoint(name string) (string, error) {
+	if cfg.ServerURL == "" {
+		port := cfg.Port
+		if port == "" {
+			port = ":3030"
+		} else if !strings.HasPrefix(port, ":") {
+			port = fmt.Sprintf(":%s", port)
+		}
+		return fmt.Sprintf("http://localhost%s%s", port, name), nil
+	}
+	u, err := parseServerURL(cfg.ServerURL)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	if u.Scheme == "unix" {
+		return "http://localhost" + name, nil
 	}
-	req.Header.Set("Content-Type", "application/json")
+	u.Path = strings.TrimSuffix(u.Path, "/") + name
+	return u.String(), nil
+}
 
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// socketPath returns the path of the unix domain socket in ServerURL or
+// an empty string if pandoc-server is reached over TCP.
+func (cfg *Config) socketPath() string {
+	if !strings.HasPrefix(cfg.ServerURL, "unix:") {
+		return ""
+	}
+	u, err := parseServerURL(cfg.ServerURL)
 	if err != nil {
-		log.Printf("%s POST failed, %s", u, err)
-		return nil, err
+		return ""
 	}
-	defer resp.Body.Close()
-	// Process response
-	src, err = io.ReadAll(resp.Body)
+	return u.Path
+}
+
+// RootEndpoint takes content type and sends the request to the Pandoc Server
+// Root end point based on the state of configuration struct used. The
+// document to convert must already be in Text, base64 encoded if it is a
+// binary format. Use Convert to convert a document without changing the
+// configuration.
+func (cfg *Config) RootEndpoint() ([]byte, error) {
+	return cfg.RootEndpointContext(context.Background())
+}
+
+// RootEndpointContext is like RootEndpoint but the request is sent
+// with the given context so it can be cancelled or given a deadline.
+func (cfg *Config) RootEndpointContext(ctx context.Context) ([]byte, error) {
+	// NOTE: The JSON response tells us if the output is base64 encoded,
+	// e.g. when converting to docx or epub, so binary output is decoded.
+	result, err := cfg.RootEndpointResultContext(ctx)
 	if err != nil {
-		log.Printf("%s POST read body failed, %s", u, err)
 		return nil, err
 	}
-	if len(src) == 0 {
+	if len(result.Output) == 0 {
 		log.Printf("zero bytes returned from Root Endpoint")
 		return nil, fmt.Errorf("zero bytes returned by pandoc")
 	}
-	if cfg.Verbose {
-		log.Printf("%d bytes returned successful from Root Endpoint", len(src))
-	}
-	return src, nil
+	return result.Output, nil
 }
 
 // Pandoc a takes the configuration settings and sends a request
 // to the Pandoc server with contents read from the io.Reader
-// and returns a slice of bytes and error.
+// and returns a slice of bytes and error. Binary input formats
+// like docx and epub are base64 encoded before sending and binary
+// output formats are returned decoded. The configuration is not
+// changed so it can be shared between goroutines.
 //
 // ```
 //
@@ -239,60 +468,75 @@ func (cfg *Config) RootEndpoint() ([]byte, error) {
 //
 // ```
 func (cfg *Config) Convert(input io.Reader) ([]byte, error) {
-	var src []byte
+	return cfg.ConvertContext(context.Background(), input)
+}
 
-	src, err := io.ReadAll(input)
+// RootEndpointResult sends the request to the Pandoc Server Root end
+// point asking for a JSON response. The Result includes pandoc's log
+// messages and the decoded output.
+func (cfg *Config) RootEndpointResult() (*Result, error) {
+	return cfg.RootEndpointResultContext(context.Background())
+}
+
+// RootEndpointResultContext is like RootEndpointResult but the request is
+// sent with the given context so it can be cancelled or given a deadline.
+func (cfg *Config) RootEndpointResultContext(ctx context.Context) (*Result, error) {
+	result, err := cfg.convert(ctx, &request{Config: cfg, From: cfg.From, Text: cfg.Text, Files: cfg.Files})
 	if err != nil {
 		return nil, err
 	}
-	// NOTE: The source needs to already be converted to bytes, if necessary base64 encoded.
-	cfg.Text = fmt.Sprintf("%s", src)
-	defer func() {
-		cfg.Text = ""
-	}()
-	src, err = cfg.RootEndpoint()
+	if cfg.Verbose {
+		log.Printf("%d bytes returned successful from Root Endpoint", len(result.Output))
+	}
+	return result, nil
+}
+
+// ConvertContext is like Convert but the request is sent with the given
+// context so it can be cancelled or given a deadline.
+func (cfg *Config) ConvertContext(ctx context.Context, input io.Reader) ([]byte, error) {
+	// NOTE: The JSON response tells us if the output is base64 encoded,
+	// e.g. when converting to docx or epub, so binary output is decoded.
+	result, err := cfg.ConvertResultContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
-	return src, nil
+	if len(result.Output) == 0 {
+		log.Printf("zero bytes returned from Root Endpoint")
+		return nil, fmt.Errorf("zero bytes returned by pandoc")
+	}
+	return result.Output, nil
 }
 
-// Walk takes a path and walks the directories converting the files that map
-// to the From values in the configuration.
-func (cfg *Config) Walk(startPath string, fromExt string, toExt string) error {
-	err := filepath.Walk(startPath,
-		func(fName string, info fs.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
-				ext := path.Ext(fName)
-				if ext == fromExt {
-					toFName := strings.TrimSuffix(fName, ext) + toExt
-					src, err := os.ReadFile(fName)
-					if err != nil {
-						log.Printf("%s", err)
-						return err
-					}
-					txt, err := cfg.Convert(bytes.NewReader(src))
-					if err != nil {
-						log.Printf("%s", err)
-						return err
-					}
-					err = os.WriteFile(toFName, txt, 0664)
-					if err != nil {
-						log.Printf("%s", err)
-						return err
-					}
-					if cfg.Verbose {
-						log.Printf("covert %q to %q\n", fName, toFName)
-					}
-				}
-			}
-			return nil
-		})
+// ConvertResult is like Convert but returns a Result which holds pandoc's
+// warnings and other log messages along with the converted document.
+//
+// ```
+//
+//	result, err := cfg.ConvertResult(bytes.NewReader(src))
+//	// ... handle error
+//	for _, msg := range result.Messages {
+//		log.Printf("%s", msg)
+//	}
+//	err = os.WriteFile("htdocs/index.html", result.Output, 0664)
+//
+// ```
+func (cfg *Config) ConvertResult(input io.Reader) (*Result, error) {
+	return cfg.ConvertResultContext(context.Background(), input)
+}
+
+// ConvertResultContext is like ConvertResult but the request is sent with
+// the given context so it can be cancelled or given a deadline.
+func (cfg *Config) ConvertResultContext(ctx context.Context, input io.Reader) (*Result, error) {
+	src, err := io.ReadAll(input)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	result, err := cfg.convert(ctx, cfg.newRequest(src, "", nil))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Verbose {
+		log.Printf("%d bytes returned successful from Root Endpoint", len(result.Output))
+	}
+	return result, nil
 }
@@ -15,11 +15,271 @@ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
 package pandoc_client
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// fakePandoc mimics the pandoc-server end points closely enough to
+// exercise the client without a running pandoc-server.
+type fakePandoc struct {
+	*httptest.Server
+	// batches counts the POSTs to the /batch end point
+	batches int32
+	// converts counts the POSTs to the root end point
+	converts int32
+	// version is reported by the /version end point
+	version string
+	// socket is the unix domain socket the server listens on, if any
+	socket string
+	// down makes the root end point answer 503 while it is non-zero
+	down int32
+}
+
+// fakeConvert "converts" a document by wrapping the text in a paragraph.
+// Binary formats get a zip container holding the paragraph. Text
+// containing "FAIL" is treated as a conversion error and text containing
+// "FROM" reports the format it was read as.
+func fakeConvert(params map[string]interface{}) ([]byte, error) {
+	text, err := fakeText(params)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(text, "FAIL") {
+		return nil, fmt.Errorf("could not convert %q", text)
+	}
+	body := fmt.Sprintf("<p>%s</p>", text)
+	if files, ok := params["files"].(map[string]interface{}); ok && len(files) > 0 {
+		names := []string{}
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		body += fmt.Sprintf("<!-- files: %s -->", strings.Join(names, ", "))
+	}
+	if strings.Contains(text, "FROM") {
+		from, _ := params["from"].(string)
+		body += fmt.Sprintf("<!-- from: %s -->", from)
+	}
+	to, _ := params["to"].(string)
+	if !isBinaryFormat(to) {
+		return []byte(body), nil
+	}
+	entries := map[string]string{
+		"[Content_Types].xml": `<?xml version="1.0" encoding="UTF-8"?><Types/>`,
+		"word/document.xml":   body,
+	}
+	if to == "epub" || to == "odt" {
+		entries = map[string]string{
+			"mimetype":               "application/" + to + "+zip",
+			"META-INF/container.xml": `<?xml version="1.0" encoding="UTF-8"?><container/>`,
+			"EPUB/text/ch001.xhtml":  body,
+		}
+	}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fakeText returns the text of the document sent. Binary formats must be
+// base64 encoded zip containers made by fakeConvert, the text is the
+// content of the container.
+func fakeText(params map[string]interface{}) (string, error) {
+	text, _ := params["text"].(string)
+	from, _ := params["from"].(string)
+	if !isBinaryFormat(from) {
+		return text, nil
+	}
+	src, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", fmt.Errorf("%s input must be base64 encoded, %s", from, err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		return "", fmt.Errorf("%s input is not a zip container, %s", from, err)
+	}
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" || f.Name == "EPUB/text/ch001.xhtml" {
+			rc, err := f.Open()
+			if err != nil {
+				return "", err
+			}
+			defer rc.Close()
+			src, err := io.ReadAll(rc)
+			return string(src), err
+		}
+	}
+	return "", fmt.Errorf("%s input is missing its content", from)
+}
+
+// fakeOutput returns the JSON object pandoc-server sends when JSON is
+// requested. Text containing "WARN" adds a warning to the messages.
+func fakeOutput(params map[string]interface{}, output []byte) map[string]interface{} {
+	messages := []interface{}{}
+	if text, _ := params["text"].(string); strings.Contains(text, "WARN") {
+		messages = append(messages, map[string]interface{}{
+			"verbosity": "WARNING",
+			"type":      "CouldNotFetchResource",
+			"path":      "missing.png",
+			"message":   "could not fetch resource",
+		})
+	}
+	to, _ := params["to"].(string)
+	if isBinaryFormat(to) {
+		return map[string]interface{}{
+			"output":   base64.StdEncoding.EncodeToString(output),
+			"base64":   true,
+			"messages": messages,
+		}
+	}
+	return map[string]interface{}{
+		"output":   string(output),
+		"base64":   false,
+		"messages": messages,
+	}
+}
+
+func newFakePandoc(t *testing.T) *fakePandoc {
+	fp := newUnstartedFakePandoc()
+	fp.Start()
+	t.Cleanup(fp.Close)
+	return fp
+}
+
+// newFakePandocSocket returns a fake server listening on a unix
+// domain socket.
+func newFakePandocSocket(t *testing.T) *fakePandoc {
+	// NOTE: socket paths are limited to about 100 characters so
+	// t.TempDir() may be too long.
+	dName, err := os.MkdirTemp("", "pandoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dName) })
+	fp := newUnstartedFakePandoc()
+	fp.socket = filepath.Join(dName, "pandoc.sock")
+	fp.Listener.Close()
+	fp.Listener, err = net.Listen("unix", fp.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.Start()
+	t.Cleanup(fp.Close)
+	return fp
+}
+
+func newUnstartedFakePandoc() *fakePandoc {
+	fp := &fakePandoc{version: "3.1.2"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fp.converts, 1)
+		if atomic.LoadInt32(&fp.down) != 0 {
+			http.Error(w, "pandoc-server is down", http.StatusServiceUnavailable)
+			return
+		}
+		params := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if text, _ := params["text"].(string); strings.Contains(text, "SLOW") {
+			// Wait for the client to give up
+			<-r.Context().Done()
+			return
+		}
+		output, err := fakeConvert(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("Accept") == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(fakeOutput(params, output))
+			return
+		}
+		w.Write(output)
+	})
+	mux.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fp.batches, 1)
+		batch := []map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		outputs := []map[string]interface{}{}
+		for _, params := range batch {
+			output, err := fakeConvert(params)
+			if err != nil {
+				outputs = append(outputs, map[string]interface{}{"error": err.Error()})
+				continue
+			}
+			outputs = append(outputs, fakeOutput(params, output))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(outputs)
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, fp.version)
+	})
+	mux.HandleFunc("/babelmark", func(w http.ResponseWriter, r *http.Request) {
+		params := map[string]interface{}{
+			"text": r.URL.Query().Get("text"),
+			"from": r.URL.Query().Get("from"),
+		}
+		output, err := fakeConvert(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"html":    string(output),
+			"version": fp.version,
+		})
+	})
+	fp.Server = httptest.NewUnstartedServer(mux)
+	return fp
+}
+
+// config returns a configuration pointed at the fake server.
+func (fp *fakePandoc) config() *Config {
+	serverURL := fp.URL
+	if fp.socket != "" {
+		serverURL = "unix://" + fp.socket
+	}
+	return &Config{
+		ServerURL: serverURL,
+		From:      "markdown",
+		To:        "html5",
+	}
+}
+
 func TestHelloWorld(t *testing.T) {
 	mdText := []byte(`---
 title: "Hello World"
@@ -65,3 +325,265 @@ Hi there Universe!
 	}
 	t.Errorf("FIXME: Need to make sure I am getting valid HTML ... ->\n%s\n", src)
 }
+
+func TestEndpoint(t *testing.T) {
+	for _, c := range []struct {
+		cfg      *Config
+		expected string
+	}{
+		{&Config{}, "http://localhost:3030/batch"},
+		{&Config{Port: "8080"}, "http://localhost:8080/batch"},
+		{&Config{Port: ":3030", ServerURL: "http://pandoc:3030"}, "http://pandoc:3030/batch"},
+		{&Config{ServerURL: "https://example.edu/pandoc/"}, "https://example.edu/pandoc/batch"},
+		{&Config{ServerURL: "unix:///run/pandoc.sock"}, "http://localhost/batch"},
+	} {
+		u, err := c.cfg.endpoint("/batch")
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if u != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, u)
+		}
+	}
+}
+
+func TestLoadServerURL(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "server-url.json")
+	for src, ok := range map[string]bool{
+		`{"server_url": "https://example.edu/pandoc/"}`: true,
+		`{"server_url": "ftp://example.edu/"}`:          false,
+		`{"server_url": "http:///pandoc"}`:              false,
+		`{"server_url": "http://example.edu/?q=1"}`:     false,
+		`{"server_url": "unix:///run/pandoc.sock"}`:     true,
+		`{"server_url": "unix://host/pandoc.sock"}`:     false,
+		`{"server_url": "unix://"}`:                     false,
+	} {
+		if err := os.WriteFile(fName, []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Load(fName)
+		if ok && err != nil {
+			t.Errorf("%s: %s", src, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	fp := newFakePandocSocket(t)
+	cfg := fp.config()
+	src, err := cfg.Convert(strings.NewReader("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<p>Hello World</p>"; string(src) != expected {
+		t.Errorf("expected %q, got %q", expected, src)
+	}
+	if _, err := cfg.ServerVersion(); err != nil {
+		t.Error(err)
+	}
+	dName := t.TempDir()
+	fName := filepath.Join(dName, "index.md")
+	if err := os.WriteFile(fName, []byte("Hi there"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Walk(dName, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	src, err = os.ReadFile(filepath.Join(dName, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<p>Hi there</p>"; string(src) != expected {
+		t.Errorf("expected %q, got %q", expected, src)
+	}
+}
+
+func TestWalkContext(t *testing.T) {
+	fp := newFakePandoc(t)
+	cfg := fp.config()
+	dName := t.TempDir()
+	fName := filepath.Join(dName, "slow.md")
+	if err := os.WriteFile(fName, []byte("SLOW"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := cfg.WalkContext(ctx, dName, ".md", ".html")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), fName) {
+		t.Errorf("expected error to name %q, got %q", fName, err)
+	}
+
+	// A cancelled context should stop the walk before any conversion.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := cfg.WalkContext(ctx, dName, ".md", ".html"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+// checkZip makes sure src is a zip container holding the named entry.
+func checkZip(t *testing.T, fName string, src []byte, entry string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		t.Errorf("%s: expected a zip container, %s", fName, err)
+		return
+	}
+	for _, f := range zr.File {
+		if f.Name == entry {
+			return
+		}
+	}
+	t.Errorf("%s: expected zip container to hold %q", fName, entry)
+}
+
+func TestBinaryOutput(t *testing.T) {
+	fp := newFakePandoc(t)
+	dName := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dName, "article.md"), []byte("Hi there"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		to, ext, entry string
+	}{
+		{"docx", ".docx", "[Content_Types].xml"},
+		{"pptx", ".pptx", "[Content_Types].xml"},
+		{"epub", ".epub", "mimetype"},
+		{"odt", ".odt", "mimetype"},
+	} {
+		cfg := fp.config()
+		cfg.To = c.to
+		if err := cfg.Walk(dName, ".md", c.ext); err != nil {
+			t.Error(err)
+			continue
+		}
+		fName := filepath.Join(dName, "article"+c.ext)
+		src, err := os.ReadFile(fName)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		checkZip(t, fName, src, c.entry)
+
+		// RootEndpoint returns the decoded bytes
+		cfg.Text = "Hi there"
+		src, err = cfg.RootEndpoint()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		checkZip(t, "RootEndpoint "+c.to, src, c.entry)
+	}
+}
+
+func TestPDFOutput(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"index.md": "home"})
+	cfgName := filepath.Join(root, "config.json")
+	for _, src := range []string{
+		`{"to": "pdf"}`,
+		`{"profiles": [{"name": "print", "to": "pdf", "ext": ".pdf"}]}`,
+		`{"profiles": [{"name": "print", "ext": ".pdf", "options": {"to": "pdf"}}]}`,
+	} {
+		if err := os.WriteFile(cfgName, []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(cfgName); err == nil || !strings.Contains(err.Error(), "latex") {
+			t.Errorf("%s: expected an error pointing to latex, got %v", src, err)
+		}
+	}
+	cfg := fp.config()
+	cfg.To = "pdf"
+	if err := cfg.Check(); err == nil {
+		t.Errorf("expected Check to reject pdf output")
+	}
+	before := atomic.LoadInt32(&fp.converts)
+	if err := cfg.Walk(root, ".md", ".pdf"); err == nil {
+		t.Errorf("expected Walk to reject pdf output")
+	}
+	if n := atomic.LoadInt32(&fp.converts) - before; n != 0 {
+		t.Errorf("expected nothing to be sent to pandoc-server, got %d conversions", n)
+	}
+}
+
+func TestBinaryInput(t *testing.T) {
+	fp := newFakePandoc(t)
+	dName := t.TempDir()
+	for _, format := range []string{"docx", "odt", "epub"} {
+		// Use the fake server to make the binary document
+		doc, err := fakeConvert(map[string]interface{}{"text": "Hi " + format, "to": format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dName, "deposit."+format), doc, 0664); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := fp.config()
+		cfg.From = format
+		src, err := cfg.Convert(bytes.NewReader(doc))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if expected := "<p><p>Hi " + format + "</p></p>"; string(src) != expected {
+			t.Errorf("expected %q, got %q", expected, src)
+		}
+
+		// Walk should read the deposit as its own format even though
+		// the configuration is for Markdown.
+		cfg = fp.config()
+		cfg.To = "markdown"
+		if err := cfg.Walk(dName, "."+format, "."+format+".md"); err != nil {
+			t.Error(err)
+			continue
+		}
+		src, err = os.ReadFile(filepath.Join(dName, "deposit."+format+".md"))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if expected := "<p><p>Hi " + format + "</p></p>"; string(src) != expected {
+			t.Errorf("expected %q, got %q", expected, src)
+		}
+		if cfg.From != "markdown" {
+			t.Errorf("expected cfg.From to be left alone, got %q", cfg.From)
+		}
+	}
+}
+
+// TestConcurrentConvert shares one Config between many goroutines, run
+// it with "go test -race" to check for data races.
+func TestConcurrentConvert(t *testing.T) {
+	fp := newFakePandoc(t)
+	cfg := fp.config()
+	cfg.MaxIdleConns = 32
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 300; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			text := fmt.Sprintf("document %d", i)
+			src, err := cfg.Convert(strings.NewReader(text))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if expected := "<p>" + text + "</p>"; string(src) != expected {
+				t.Errorf("expected %q, got %q", expected, src)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if cfg.Text != "" {
+		t.Errorf("expected cfg.Text to be left alone, got %q", cfg.Text)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// PandocVersion holds the numeric parts of a pandoc version number,
+// e.g. "3.1.2" is PandocVersion{3, 1, 2}.
+type PandocVersion []int
+
+var (
+	// MinPandocVersion is the oldest pandoc-server this package supports.
+	// pandoc-server first shipped with pandoc 3.0, which also takes
+	// embed-resources rather than the older self-contained.
+	MinPandocVersion = PandocVersion{3, 0}
+
+	// capabilities lists the configuration options and formats which need
+	// a newer pandoc than MinPandocVersion along with the version that
+	// added them.
+	capabilities = []struct {
+		option string
+		since  PandocVersion
+		isSet  func(cfg *Config) bool
+	}{
+		{"typst output", PandocVersion{3, 1, 2}, func(cfg *Config) bool { return cfg.writes("typst") }},
+		{"djot input", PandocVersion{3, 1, 12}, func(cfg *Config) bool { return cfg.reads("djot") }},
+		{"djot output", PandocVersion{3, 1, 12}, func(cfg *Config) bool { return cfg.writes("djot") }},
+	}
+)
+
+// baseFormat strips extensions like "+smart" from a pandoc format.
+func baseFormat(format string) string {
+	if i := strings.IndexAny(format, "+-"); i > -1 {
+		return format[:i]
+	}
+	return format
+}
+
+// reads returns true if the configuration reads format, either as From
+// or through ExtTypes and SourceExts.
+func (cfg *Config) reads(format string) bool {
+	if baseFormat(cfg.From) == format {
+		return true
+	}
+	for _, ext := range cfg.SourceExts {
+		if f, ok := cfg.extType(ext); ok && baseFormat(f) == format {
+			return true
+		}
+	}
+	return false
+}
+
+// writes returns true if the configuration writes format, either as To
+// or in one of its Profiles.
+func (cfg *Config) writes(format string) bool {
+	if baseFormat(cfg.To) == format {
+		return true
+	}
+	for _, profile := range cfg.Profiles {
+		if baseFormat(profile.To) == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePandocVersion parses a version string like "3.1.2" as returned
+// by pandoc-server's /version end point.
+func ParsePandocVersion(s string) (PandocVersion, error) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if s == "" {
+		return nil, fmt.Errorf("missing pandoc version")
+	}
+	v := PandocVersion{}
+	for _, part := range strings.Split(s, ".") {
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("%q is not a valid pandoc version", s)
+		}
+		v = append(v, i)
+	}
+	return v, nil
+}
+
+// String returns the version in dotted form, e.g. "3.1.2".
+func (v PandocVersion) String() string {
+	parts := []string{}
+	for _, i := range v {
+		parts = append(parts, strconv.Itoa(i))
+	}
+	return strings.Join(parts, ".")
+}
+
+// Compare returns -1 if v is older than other, 1 if v is newer and 0 if
+// they are the same version. Missing parts are treated as zero so 2.19
+// and 2.19.0 are the same version.
+func (v PandocVersion) Compare(other PandocVersion) int {
+	for i := 0; i < len(v) || i < len(other); i++ {
+		a, b := 0, 0
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(other) {
+			b = other[i]
+		}
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
+
+// ServerVersion asks pandoc-server for its version using the /version
+// end point.
+func (cfg *Config) ServerVersion() (PandocVersion, error) {
+	src, err := cfg.send(context.Background(), "GET", "/version", nil, "text/plain", nil)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePandocVersion(string(src))
+}
+
+// Check asks pandoc-server for its version and returns an error if the
+// server is older than MinPandocVersion, if pdf output is asked for, if
+// an option set in the
+// configuration needs a newer pandoc than the server reports or if a
+// file named in the configuration is missing from Files. Files aren't
+// checked when CollectResources is set since they are collected for
+// each document.
+func (cfg *Config) Check() error {
+	if cfg.writes("pdf") {
+		return checkOutputFormat("pdf")
+	}
+	v, err := cfg.ServerVersion()
+	if err != nil {
+		return err
+	}
+	problems := []string{}
+	if v.Compare(MinPandocVersion) < 0 {
+		problems = append(problems, fmt.Sprintf("pandoc %s or better is required", MinPandocVersion))
+	}
+	for _, c := range capabilities {
+		if c.isSet(cfg) && v.Compare(c.since) < 0 {
+			problems = append(problems, fmt.Sprintf("%s requires pandoc %s or better", c.option, c.since))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("pandoc-server is version %s, %s", v, strings.Join(problems, ", "))
+	}
+	// NOTE: With CollectResources the files are read from beside each
+	// document when it is converted so they needn't be in Files.
+	if !cfg.CollectResources {
+		if missing := cfg.MissingFiles(); len(missing) > 0 {
+			return fmt.Errorf("%s not found in files", strings.Join(missing, ", "))
+		}
+	}
+	if cfg.Verbose {
+		log.Printf("pandoc-server is version %s", v)
+	}
+	return nil
+}
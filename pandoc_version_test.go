@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePandocVersion(t *testing.T) {
+	for s, expected := range map[string]string{
+		"3.1.2":      "3.1.2",
+		"2.19\n":     "2.19",
+		`"3.1.11.1"`: "3.1.11.1",
+		" 3.0 ":      "3.0",
+	} {
+		v, err := ParsePandocVersion(s)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if v.String() != expected {
+			t.Errorf("expected %q, got %q", expected, v)
+		}
+	}
+	for _, s := range []string{"", "three", "3.x", "3..1"} {
+		if _, err := ParsePandocVersion(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestPandocVersionCompare(t *testing.T) {
+	for _, c := range []struct {
+		a, b     PandocVersion
+		expected int
+	}{
+		{PandocVersion{2, 19}, PandocVersion{2, 19, 0}, 0},
+		{PandocVersion{2, 18}, PandocVersion{2, 19}, -1},
+		{PandocVersion{3}, PandocVersion{2, 19, 2}, 1},
+		{PandocVersion{3, 1, 11, 1}, PandocVersion{3, 1, 11}, 1},
+	} {
+		if got := c.a.Compare(c.b); got != c.expected {
+			t.Errorf("%s compared to %s, expected %d, got %d", c.a, c.b, c.expected, got)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fp := newFakePandoc(t)
+	cfg := fp.config()
+	v, err := cfg.ServerVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "3.1.2" {
+		t.Errorf("expected 3.1.2, got %s", v)
+	}
+	cfg.EmbedResources = "true"
+	if err := cfg.Check(); err != nil {
+		t.Error(err)
+	}
+	fp.version = "2.19"
+	if err := cfg.Check(); err == nil {
+		t.Errorf("expected an error for pandoc 2.19")
+	}
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	fp := newFakePandoc(t)
+	fp.version = "3.0.1"
+	cfg := fp.config()
+	cfg.From, cfg.To = "markdown", "html5"
+	if err := cfg.Check(); err != nil {
+		t.Error(err)
+	}
+	for _, c := range []struct {
+		setup    func(cfg *Config)
+		expected string
+	}{
+		{func(cfg *Config) { cfg.To = "typst+smart" }, "typst output requires pandoc 3.1.2 or better"},
+		{func(cfg *Config) { cfg.From = "djot" }, "djot input requires pandoc 3.1.12 or better"},
+		{func(cfg *Config) {
+			cfg.Profiles = []*Profile{{Name: "djot", To: "djot", Ext: ".dj"}}
+		}, "djot output requires pandoc 3.1.12 or better"},
+	} {
+		cfg := fp.config()
+		cfg.From, cfg.To = "markdown", "html5"
+		c.setup(cfg)
+		err := cfg.Check()
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected %q, got %v", c.expected, err)
+		}
+		if err != nil && strings.Contains(err.Error(), "or better is required") {
+			t.Errorf("expected only the option to be reported, got %v", err)
+		}
+	}
+
+	// The same options are fine with a new enough pandoc
+	fp.version = "3.1.12"
+	cfg.To = "typst"
+	cfg.From = "djot"
+	if err := cfg.Check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckMissingFiles(t *testing.T) {
+	fp := newFakePandoc(t)
+	cfg := fp.config()
+	cfg.Bibliography = []string{"refs.bib"}
+	cfg.Csl = "chicago.csl"
+	err := cfg.Check()
+	if err == nil {
+		t.Fatal("expected an error for the missing files")
+	}
+	if expected := "refs.bib, chicago.csl not found in files"; err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err)
+	}
+	cfg.CollectResources = true
+	if err := cfg.Check(); err != nil {
+		t.Errorf("expected the files to be collected per document, got %s", err)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PlanStep describes what Walk would do with one output file.
+type PlanStep struct {
+	// Action is "convert", "skip", "remove" or "error"
+	Action string `json:"action"`
+	// Source is the file converted, empty when an orphan is removed
+	Source string `json:"source,omitempty"`
+	// Output is the file written or removed
+	Output string `json:"output"`
+	// Profile is the name of the profile the output is for, if any
+	Profile string `json:"profile,omitempty"`
+	// From and To are the pandoc formats used
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Reason explains the action, e.g. "up to date" or "changed"
+	Reason string `json:"reason,omitempty"`
+}
+
+// String returns the step as a line of text, e.g.
+// `convert "index.md" to "index.html" (changed)`.
+func (step *PlanStep) String() string {
+	var s string
+	switch step.Action {
+	case "remove":
+		s = fmt.Sprintf("remove %q", step.Output)
+	case "error":
+		s = fmt.Sprintf("error %q", step.Source)
+	default:
+		s = fmt.Sprintf("%s %q to %q", step.Action, step.Source, step.Output)
+	}
+	if step.Reason != "" {
+		s += fmt.Sprintf(" (%s)", step.Reason)
+	}
+	return s
+}
+
+// Plan returns what Walk would do, in walk order, without contacting
+// pandoc-server or writing anything. The sources are read so the same
+// decisions about which files to skip are made as in a real walk. A file
+// which can't be prepared, e.g. because a resource escapes the source
+// tree, is an "error" step. When Prune is set the orphans are listed as
+// "remove" steps.
+func (cfg *Config) Plan(startPath string, fromExt string, toExt string) ([]*PlanStep, error) {
+	ctx := context.Background()
+	w, err := cfg.newWalker(startPath, fromExt, toExt)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := cfg.findJobs(ctx, w, fromExt)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.plan(ctx, w, jobs)
+}
+
+// plan works out the steps for the files found by findJobs, followed by
+// the orphans removed when Prune is set.
+func (cfg *Config) plan(ctx context.Context, w *walker, jobs []*walkJob) ([]*PlanStep, error) {
+	steps, err := cfg.planJobs(ctx, w, jobs)
+	if err != nil {
+		return steps, err
+	}
+	if cfg.Prune {
+		orphans, err := cfg.findOrphans(ctx, w, jobs)
+		if err != nil {
+			return steps, err
+		}
+		for _, fName := range orphans {
+			steps = append(steps, &PlanStep{Action: "remove", Output: fName, Reason: "no source"})
+		}
+	}
+	return steps, nil
+}
+
+// planJobs works out the steps for converting jobs. A job which can't be
+// prepared records the failure in its errs, see planError.
+func (cfg *Config) planJobs(ctx context.Context, w *walker, jobs []*walkJob) ([]*PlanStep, error) {
+	if cfg.SkipUnchanged {
+		w.manifest = loadManifest(w.outputPath)
+	}
+	steps := []*PlanStep{}
+	for _, job := range jobs {
+		if err := ctx.Err(); err != nil {
+			return steps, err
+		}
+		if err := cfg.prepareJob(w, job); err != nil {
+			fileErr, ok := err.(*FileError)
+			if !ok {
+				fileErr = newFileError(StagePrepare, job.fName, "", err)
+			}
+			job.errs = append(job.errs, fileErr)
+			steps = append(steps, &PlanStep{Action: "error", Source: job.fName, From: job.from, Reason: err.Error()})
+			continue
+		}
+		for _, out := range job.outputs {
+			step := &PlanStep{
+				Action:  "convert",
+				Source:  job.fName,
+				Output:  out.toFName,
+				Profile: out.target.name,
+				From:    job.from,
+				To:      out.target.cfg.To,
+			}
+			output, err := filepath.Rel(w.outputPath, out.toFName)
+			if err != nil {
+				return steps, err
+			}
+			output = filepath.ToSlash(output)
+			_, statErr := os.Stat(out.toFName)
+			switch {
+			case cfg.upToDate(w, output, out):
+				step.Action, step.Reason = "skip", "up to date"
+			case w.manifest == nil:
+			case cfg.Force:
+				step.Reason = "forced"
+			case statErr != nil:
+				step.Reason = "not converted yet"
+			default:
+				step.Reason = "changed"
+			}
+			steps = append(steps, step)
+		}
+	}
+	return steps, nil
+}
+
+// planError returns the error a real walk of jobs would return for the
+// files which can't be prepared, nil if there are none. Like runJobs it
+// is the first failure unless the walk continues on errors.
+func planError(w *walker, jobs []*walkJob) error {
+	report := &WalkErrors{Files: len(jobs)}
+	for _, job := range jobs {
+		report.Errors = append(report.Errors, job.errs...)
+	}
+	switch {
+	case len(report.Errors) == 0:
+		return nil
+	case w.continueOnError:
+		return report
+	default:
+		return report.Errors[0]
+	}
+}
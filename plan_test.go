@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	fp := newFakePandoc(t)
+	root, outDir := t.TempDir(), t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"index.md":       "home",
+		"guide.rst":      "guide",
+		"drafts/next.md": "draft",
+		"old.md":         "old",
+		"broken.md":      "![escape](../../secret.png)",
+	})
+	cfg := fp.config()
+	cfg.OutputDir = outDir
+	cfg.SkipUnchanged = true
+	cfg.CollectResources = true
+	cfg.Exclude = []string{"drafts/", "broken.md"}
+	// describe turns the plan into text relative to the two trees
+	describe := func(steps []*PlanStep) string {
+		lines := []string{}
+		for _, step := range steps {
+			src, _ := filepath.Rel(root, step.Source)
+			out, _ := filepath.Rel(outDir, step.Output)
+			if step.Source == "" {
+				src = ""
+			}
+			if step.Output == "" {
+				out = ""
+			}
+			lines = append(lines, strings.Join([]string{step.Action, src, out, step.From, step.Reason}, " "))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	steps, err := cfg.Plan(root, "", ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `convert guide.rst guide.html rst not converted yet
+convert index.md index.html markdown not converted yet
+convert old.md old.html markdown not converted yet`
+	if got := describe(steps); got != expected {
+		t.Errorf("expected plan\n%s\ngot\n%s", expected, got)
+	}
+	if n := atomic.LoadInt32(&fp.converts); n != 0 {
+		t.Errorf("expected no conversions, got %d", n)
+	}
+	if entries, _ := os.ReadDir(outDir); len(entries) != 0 {
+		t.Errorf("expected nothing to be written, found %d files", len(entries))
+	}
+
+	if err := cfg.Walk(root, "", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{"guide.rst": "new guide"})
+	if err := os.Remove(filepath.Join(root, "old.md")); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Prune = true
+	cfg.Exclude = []string{"drafts/"}
+	steps, err = cfg.Plan(root, "", ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `error broken.md  markdown collecting resources for "` + filepath.Join(root, "broken.md") + `": "../../secret.png" is outside of "` + root + `"
+convert guide.rst guide.html rst changed
+skip index.md index.html markdown up to date
+remove  old.html  no source`
+	if got := describe(steps); got != expected {
+		t.Errorf("expected plan\n%s\ngot\n%s", expected, got)
+	}
+
+	// A dry run Walk logs the plan and leaves everything alone
+	before := atomic.LoadInt32(&fp.converts)
+	cfg.DryRun = true
+	// but fails like a real walk would for broken.md
+	err = cfg.Walk(root, "", ".html")
+	var fileErr *FileError
+	if !errors.As(err, &fileErr) || fileErr.Stage != StageResources || fileErr.Path != filepath.Join(root, "broken.md") {
+		t.Errorf("expected broken.md to fail collecting resources, got %v", err)
+	}
+	cfg.ContinueOnError = true
+	err = cfg.Walk(root, "", ".html")
+	var report *WalkErrors
+	if !errors.As(err, &report) || report.Failed() != 1 {
+		t.Errorf("expected a *WalkErrors for broken.md, got %v", err)
+	}
+	cfg.Exclude = append(cfg.Exclude, "broken.md")
+	if err := cfg.Walk(root, "", ".html"); err != nil {
+		t.Errorf("expected no error once broken.md is excluded, got %v", err)
+	}
+	if n := atomic.LoadInt32(&fp.converts) - before; n != 0 {
+		t.Errorf("expected no conversions, got %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "old.html")); err != nil {
+		t.Errorf("expected old.html to be left alone, %s", err)
+	}
+}
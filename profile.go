@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Profile is a named output Walk produces for every source file, e.g. an
+// "html" profile writing ".html" files and an "epub" profile writing
+// ".epub" files. Each source is read once and converted once per profile.
+type Profile struct {
+	// Name identifies the profile in log messages
+	Name string `json:"name"`
+	// To is the pandoc format written, overrides To in the Config
+	To string `json:"to,omitempty"`
+	// Ext is the extension of the files written, e.g. ".html"
+	Ext string `json:"ext"`
+	// Options holds Config JSON fields which override the configuration
+	// for this profile, e.g. {"standalone": true, "table-of-contents": true}
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// profileConfig returns a copy of the configuration with the profile's
+// overrides applied, cfg is left untouched.
+func (cfg *Config) profileConfig(profile *Profile) (*Config, error) {
+	// NOTE: The copy goes through JSON so the maps and slices of the
+	// copy are not shared with cfg when the overrides are decoded.
+	src, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pcfg := new(Config)
+	if err := json.Unmarshal(src, pcfg); err != nil {
+		return nil, err
+	}
+	if len(profile.Options) > 0 {
+		// NOTE: Unknown options are rejected so a misspelt option
+		// isn't silently ignored.
+		dec := json.NewDecoder(bytes.NewReader(profile.Options))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(pcfg); err != nil {
+			return nil, fmt.Errorf("profile %q: %s", profile.Name, err)
+		}
+	}
+	if profile.To != "" {
+		pcfg.To = profile.To
+	}
+	pcfg.Client, pcfg.Cache, pcfg.Profiles = cfg.Client, cfg.Cache, nil
+	return pcfg, nil
+}
+
+// checkProfiles makes sure each profile has a unique name and extension,
+// that its options can be applied and that pandoc-server can write its
+// format. A profile may not write files with
+// the extension of the sources, fromExt or SourceExts when it is empty,
+// or the walk would overwrite the files it reads.
+func (cfg *Config) checkProfiles(fromExt string) error {
+	sources := []string{fromExt}
+	if fromExt == "" {
+		sources = cfg.SourceExts
+		if len(sources) == 0 {
+			sources = DefaultSourceExts
+		}
+	}
+	isSource := map[string]bool{}
+	for _, ext := range sources {
+		isSource[strings.ToLower(ext)] = true
+	}
+	names, exts := map[string]bool{}, map[string]bool{}
+	for i, profile := range cfg.Profiles {
+		if profile.Name == "" {
+			return fmt.Errorf("profile %d is missing a name", i+1)
+		}
+		if !strings.HasPrefix(profile.Ext, ".") {
+			return fmt.Errorf("profile %q: %q is not an extension", profile.Name, profile.Ext)
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("profile %q is defined more than once", profile.Name)
+		}
+		ext := strings.ToLower(profile.Ext)
+		if isSource[ext] {
+			return fmt.Errorf("profile %q: %q is the extension of the source files", profile.Name, profile.Ext)
+		}
+		if exts[ext] {
+			return fmt.Errorf("profile %q: %q is used by another profile", profile.Name, profile.Ext)
+		}
+		names[profile.Name], exts[ext] = true, true
+		pcfg, err := cfg.profileConfig(profile)
+		if err != nil {
+			return err
+		}
+		if err := checkOutputFormat(pcfg.To); err != nil {
+			return fmt.Errorf("profile %q: %s", profile.Name, err)
+		}
+	}
+	return nil
+}
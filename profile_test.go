@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileConfig(t *testing.T) {
+	cfg := &Config{
+		To:         "html5",
+		Standalone: true,
+		Variables:  map[string]interface{}{"lang": "en"},
+		Exclude:    []string{"drafts/"},
+		Client:     NewClient(&Config{}),
+	}
+	profile := &Profile{
+		Name:    "print",
+		To:      "latex",
+		Ext:     ".tex",
+		Options: []byte(`{"table-of-contents": true, "variables": {"documentclass": "article"}, "exclude": ["print/"]}`),
+	}
+	pcfg, err := cfg.profileConfig(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pcfg.To != "latex" || !pcfg.TableOfContents || !pcfg.Standalone {
+		t.Errorf("expected the profile options to be applied, got %+v", pcfg)
+	}
+	if pcfg.Variables["lang"] != "en" || pcfg.Variables["documentclass"] != "article" {
+		t.Errorf("expected the variables to be merged, got %v", pcfg.Variables)
+	}
+	if pcfg.Client != cfg.Client {
+		t.Errorf("expected the profile to share the client")
+	}
+	if cfg.To != "html5" || cfg.TableOfContents || len(cfg.Variables) != 1 || cfg.Exclude[0] != "drafts/" {
+		t.Errorf("expected the configuration to be left alone, got %+v", cfg)
+	}
+
+	profile.Options = []byte(`{"table-of-contents": "yes"}`)
+	if _, err := cfg.profileConfig(profile); err == nil {
+		t.Errorf("expected an error for a bad option")
+	}
+	profile.Options = []byte(`{"toc": true}`)
+	if _, err := cfg.profileConfig(profile); err == nil || !strings.Contains(err.Error(), "toc") {
+		t.Errorf("expected an error naming the unknown option, got %v", err)
+	}
+	for _, profiles := range [][]*Profile{
+		{{Ext: ".html"}},
+		{{Name: "web", Ext: "html"}},
+		{{Name: "web", Ext: ".html"}, {Name: "web", Ext: ".htm"}},
+		{{Name: "web", Ext: ".html"}, {Name: "site", Ext: ".HTML"}},
+		{{Name: "source", Ext: ".md"}},
+		{{Name: "source", Ext: ".Markdown"}},
+	} {
+		cfg.Profiles = profiles
+		if err := cfg.checkProfiles(""); err == nil {
+			t.Errorf("expected an error for %+v", profiles)
+		}
+	}
+
+	// The profile extension can't be the extension of the files read
+	cfg.Profiles = []*Profile{{Name: "text", Ext: ".txt"}}
+	if err := cfg.checkProfiles(""); err != nil {
+		t.Errorf("expected .txt to be allowed, %s", err)
+	}
+	if err := cfg.checkProfiles(".txt"); err == nil {
+		t.Errorf("expected an error writing .txt files when reading .txt files")
+	}
+	cfg.SourceExts = []string{".txt"}
+	if err := cfg.checkProfiles(""); err == nil {
+		t.Errorf("expected an error writing .txt files when .txt is a source extension")
+	}
+	cfg.SourceExts = nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// errPruneManifest is returned when orphans are looked for without the
+// manifest which records the outputs.
+var errPruneManifest = fmt.Errorf("pruning needs skip_unchanged, only outputs recorded in the manifest are pruned")
+
+// Orphans returns the files in the output tree which look like Walk wrote
+// them, they end in toExt or one of the Profiles' extensions, but which
+// have no source file any more, e.g. "old-page.html" once "old-page.md"
+// has been deleted. Nothing is removed, see Prune. Only files recorded in
+// the manifest, see SkipUnchanged which must be set, are returned so hand
+// written files and files written by other tools are left alone, wherever
+// the output tree is.
+func (cfg *Config) Orphans(startPath string, fromExt string, toExt string) ([]string, error) {
+	if !cfg.SkipUnchanged {
+		return nil, errPruneManifest
+	}
+	ctx := context.Background()
+	w, err := cfg.newWalker(startPath, fromExt, toExt)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := cfg.findJobs(ctx, w, fromExt)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.findOrphans(ctx, w, jobs)
+}
+
+// findOrphans walks the output tree for files with the extensions of the
+// walk's outputs which are in the manifest but which none of jobs is
+// converted to.
+func (cfg *Config) findOrphans(ctx context.Context, w *walker, jobs []*walkJob) ([]string, error) {
+	exts, outputs := map[string]bool{}, map[string]bool{}
+	for _, target := range w.targets {
+		exts[target.ext] = true
+	}
+	for _, job := range jobs {
+		// NOTE: The sources under a directory which couldn't be read are
+		// unknown so their outputs could be taken for orphans.
+		if job.walkErr != nil && len(job.outputs) == 0 {
+			return nil, fmt.Errorf("can't look for orphans, %s", job.walkErr)
+		}
+		for _, out := range job.outputs {
+			outputs[out.toFName] = true
+		}
+	}
+	inPlace := filepath.Clean(w.outputPath) == filepath.Clean(w.startPath)
+	m := loadManifest(w.outputPath)
+	var (
+		filter *walkFilter
+		err    error
+	)
+	if inPlace {
+		if filter, err = cfg.newWalkFilter(w.startPath); err != nil {
+			return nil, err
+		}
+	}
+	startPath, err := filepath.Abs(w.startPath)
+	if err != nil {
+		return nil, err
+	}
+	orphans := []string{}
+	err = filepath.Walk(w.outputPath,
+		func(fName string, info fs.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && fName == w.outputPath {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(w.outputPath, fName)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if rel == "." {
+					return nil
+				}
+				// NOTE: A source tree inside the output tree is not pruned.
+				if absName, err := filepath.Abs(fName); err == nil && absName == startPath && !inPlace {
+					return filepath.SkipDir
+				}
+				if filter != nil && filter.excluded(rel, true) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !exts[path.Ext(fName)] || outputs[fName] || info.Name() == ManifestName {
+				return nil
+			}
+			// NOTE: Only files this tool wrote are ever orphans.
+			if _, ok := m.Outputs[filepath.ToSlash(rel)]; !ok {
+				return nil
+			}
+			orphans = append(orphans, fName)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
+
+// pruneOrphans removes the orphaned outputs and their manifest entries.
+func (cfg *Config) pruneOrphans(w *walker, orphans []string) error {
+	if len(orphans) == 0 {
+		return nil
+	}
+	m := loadManifest(w.outputPath)
+	var firstErr error
+	for _, fName := range orphans {
+		if err := os.Remove(fName); err != nil && !os.IsNotExist(err) {
+			log.Printf("%s", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if rel, err := filepath.Rel(w.outputPath, fName); err == nil {
+			m.remove(filepath.ToSlash(rel))
+		}
+		if cfg.Verbose {
+			log.Printf("prune %q, it has no source", fName)
+		}
+	}
+	if _, err := os.Stat(m.fName); err == nil || cfg.SkipUnchanged {
+		if err := m.save(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
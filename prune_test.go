@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	fp := newFakePandoc(t)
+	root, outDir := t.TempDir(), t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"index.md":        "home",
+		"old-page.md":     "old",
+		"news/old-new.md": "old news",
+	})
+	cfg := fp.config()
+	cfg.OutputDir = outDir
+	cfg.SkipUnchanged = true
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, outDir, map[string]string{
+		"style.css":      "not an output",
+		"news/feed.html": "written by another tool",
+	})
+	for _, name := range []string{"old-page.md", "news/old-new.md"} {
+		if err := os.Remove(filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orphans, err := cfg.Orphans(root, ".md", ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fName := range orphans {
+		rel, _ := filepath.Rel(outDir, fName)
+		orphans[i] = filepath.ToSlash(rel)
+	}
+	expected := "news/old-new.html old-page.html"
+	if got := strings.Join(orphans, " "); got != expected {
+		t.Errorf("expected orphans %q, got %q", expected, got)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "old-page.html")); err != nil {
+		t.Errorf("Orphans should not remove anything, %s", err)
+	}
+
+	// Without the manifest nothing could be pruned
+	cfg.Prune, cfg.SkipUnchanged = true, false
+	if err := cfg.Walk(root, ".md", ".html"); err == nil {
+		t.Errorf("expected an error pruning without SkipUnchanged")
+	}
+	if _, err := cfg.Orphans(root, ".md", ".html"); err == nil {
+		t.Errorf("expected an error looking for orphans without SkipUnchanged")
+	}
+
+	cfg.SkipUnchanged = true
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"old-page.html", "news/old-new.html"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err == nil {
+			t.Errorf("expected %s to be pruned", name)
+		}
+	}
+	for _, name := range []string{"index.html", "style.css", "news/feed.html", ManifestName} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("expected %s to be kept, %s", name, err)
+		}
+	}
+	if _, ok := loadManifest(outDir).Outputs["old-page.html"]; ok {
+		t.Errorf("expected old-page.html to be removed from the manifest")
+	}
+}
+
+func TestPruneInPlace(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"index.md":    "home",
+		"old-page.md": "old",
+		"about.html":  "hand written",
+	})
+	cfg := fp.config()
+	cfg.SkipUnchanged = true
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "old-page.md")); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Prune = true
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old-page.html")); err == nil {
+		t.Errorf("expected old-page.html to be pruned")
+	}
+	for _, name := range []string{"index.html", "about.html"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("expected %s to be kept, %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// request is the JSON document POSTed to pandoc-server. It is built for
+// each conversion so the Config it is made from is never changed and can
+// be shared between goroutines.
+type request struct {
+	*Config
+	// From, Text and Files take the place of the Config fields of the
+	// same name when the request is encoded.
+	From  string
+	Text  string
+	Files Files
+}
+
+// clientOptions are the JSON names of the Config fields tagged
+// `client:"true"`. They configure this package rather than pandoc so
+// they are not sent to pandoc-server.
+var clientOptions = func() []string {
+	names := []string{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("client") != "true" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}()
+
+// MarshalJSON encodes the pandoc options of the configuration along with
+// the request's from, text and files. The keys are sorted so the same
+// request always encodes the same way.
+func (req *request) MarshalJSON() ([]byte, error) {
+	src, err := json.Marshal(req.Config)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(src, &fields); err != nil {
+		return nil, err
+	}
+	for _, key := range clientOptions {
+		delete(fields, key)
+	}
+	for key, val := range map[string]interface{}{
+		"from":  req.From,
+		"text":  req.Text,
+		"files": req.Files,
+	} {
+		delete(fields, key)
+		if src, err = json.Marshal(val); err != nil {
+			return nil, err
+		}
+		if string(src) != `""` && string(src) != "null" && string(src) != "{}" {
+			fields[key] = src
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// newRequest returns a request to convert src. The from format is used
+// instead of the configuration's From if it is not empty and files are
+// sent along with the configuration's Files.
+func (cfg *Config) newRequest(src []byte, from string, files Files) *request {
+	req := &request{
+		Config: cfg,
+		From:   cfg.From,
+		Files:  cfg.Files,
+	}
+	if from != "" {
+		req.From = from
+	}
+	// NOTE: Binary input formats like docx must be base64 encoded.
+	if isBinaryFormat(req.From) {
+		req.Text = base64.StdEncoding.EncodeToString(src)
+	} else {
+		req.Text = string(src)
+	}
+	if len(files) > 0 {
+		req.Files = Files{}
+		for name, val := range cfg.Files {
+			req.Files[name] = val
+		}
+		for name, val := range files {
+			req.Files[name] = val
+		}
+	}
+	return req
+}
+
+// convert sends the request to the root end point and returns the
+// decoded Result. If the configuration has a Cache it is checked first.
+func (cfg *Config) convert(ctx context.Context, req *request) (*Result, error) {
+	if cfg.Cache != nil {
+		return cfg.cachedConvert(ctx, req)
+	}
+	return cfg.postRequest(ctx, req)
+}
+
+// postRequest sends the request to the root end point asking for a JSON
+// response and returns the decoded Result.
+func (cfg *Config) postRequest(ctx context.Context, req *request) (*Result, error) {
+	if req.Text == "" {
+		return nil, fmt.Errorf("expected to have a source text to convert, %+v", cfg)
+	}
+	src, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	src, err = cfg.send(ctx, "POST", "/", nil, "application/json", src)
+	if err != nil {
+		return nil, err
+	}
+	o := new(output)
+	if err := json.Unmarshal(src, o); err != nil {
+		return nil, err
+	}
+	return o.result()
+}
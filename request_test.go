@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewRequest(t *testing.T) {
+	cfg := &Config{
+		From:  "markdown",
+		To:    "html5",
+		Text:  "left over text",
+		Files: Files{"a.png": "UE5H"},
+	}
+	src, err := json.Marshal(cfg.newRequest([]byte("PK"), "docx", Files{"b.png": "SUNPTg=="}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(src, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["from"] != "docx" {
+		t.Errorf("expected from to be docx, got %v", doc["from"])
+	}
+	if doc["to"] != "html5" {
+		t.Errorf("expected to to be html5, got %v", doc["to"])
+	}
+	if doc["text"] != "UEs=" {
+		t.Errorf("expected base64 encoded text, got %v", doc["text"])
+	}
+	files, _ := doc["files"].(map[string]interface{})
+	if len(files) != 2 || files["a.png"] != "UE5H" || files["b.png"] != "SUNPTg==" {
+		t.Errorf("expected both files, got %v", doc["files"])
+	}
+	if len(cfg.Files) != 1 || cfg.Text != "left over text" || cfg.From != "markdown" {
+		t.Errorf("expected the configuration to be left alone, got %+v", cfg)
+	}
+}
+
+func TestRequestLeavesOutClientOptions(t *testing.T) {
+	cfg := &Config{
+		Port:       ":3030",
+		ServerURL:  "http://localhost:3030",
+		Verbose:    true,
+		Workers:    4,
+		BatchSize:  10,
+		Standalone: true,
+	}
+	src, err := json.Marshal(cfg.newRequest([]byte("Hello"), "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"standalone":true,"text":"Hello"}`
+	if string(src) != expected {
+		t.Errorf("expected %s, got %s", expected, src)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	// Every setting of this package must be left out of requests
+	for _, name := range []string{"port", "server_url", "Verbose", "timeout", "workers",
+		"skip_unchanged", "cache_dir", "output_dir", "profiles", "file_mode"} {
+		found := false
+		for _, option := range clientOptions {
+			found = found || option == name
+		}
+		if !found {
+			t.Errorf("expected %q to be a client option", name)
+		}
+	}
+	// Pandoc's options use kebab-case, this package's use snake_case
+	t.Run("snake_case", func(t *testing.T) {
+		typ := reflect.TypeOf(Config{})
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if strings.Contains(name, "_") && field.Tag.Get("client") != "true" {
+				t.Errorf("%s should be tagged client:\"true\"", field.Name)
+			}
+		}
+	})
+	for _, name := range []string{"from", "to", "standalone", "files", "text"} {
+		for _, option := range clientOptions {
+			if option == name {
+				t.Errorf("%q should be sent to pandoc-server", name)
+			}
+		}
+	}
+}
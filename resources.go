@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultMaxResourceBytes is used when Config.MaxResourceBytes is not set.
+	DefaultMaxResourceBytes = 16 * 1024 * 1024
+)
+
+var (
+	// markdownImageRE matches Markdown images, e.g. ![alt](images/a.png "title")
+	markdownImageRE = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^)\s>]+)`)
+	// htmlImageRE matches the src attribute of HTML img elements
+	htmlImageRE = regexp.MustCompile(`(?i)<img\s[^>]*src\s*=\s*["']([^"']+)["']`)
+	// metadataRE matches the metadata fields naming files, e.g. "bibliography: refs.bib"
+	metadataRE = regexp.MustCompile(`^(bibliography|csl|reference-doc|cover-image)\s*:\s*(.*)$`)
+	// listItemRE matches an item in a YAML list, e.g. "  - refs.bib"
+	listItemRE = regexp.MustCompile(`^\s+-\s+(.+)$`)
+)
+
+// metadataPaths returns the file paths named by the bibliography, csl,
+// reference-doc and cover-image fields of a YAML metadata block at the
+// start of a document.
+func metadataPaths(src []byte) []string {
+	paths := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	inList := false
+	for i := 0; scanner.Scan(); i++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if i == 0 {
+			if line != "---" {
+				break
+			}
+			continue
+		}
+		if line == "---" || line == "..." {
+			break
+		}
+		if m := listItemRE.FindStringSubmatch(line); inList && m != nil {
+			paths = append(paths, unquote(m[1]))
+			continue
+		}
+		inList = false
+		if m := metadataRE.FindStringSubmatch(line); m != nil {
+			if val := unquote(m[2]); val != "" {
+				paths = append(paths, val)
+			} else {
+				inList = true
+			}
+		}
+	}
+	return paths
+}
+
+// unquote removes YAML quotes from a scalar value.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > 1 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// isLocalPath returns true if the link refers to a file rather than a
+// URL or a fragment in the same document.
+func isLocalPath(link string) bool {
+	if link == "" || strings.HasPrefix(link, "#") {
+		return false
+	}
+	if u, err := url.Parse(link); err == nil && u.Scheme != "" {
+		return false
+	}
+	return true
+}
+
+// CollectFiles returns the local files a document refers to so they can
+// be sent to pandoc-server with it. These are the images in Markdown and
+// HTML, the bibliography, csl, reference-doc and cover-image metadata
+// fields and the file paths in the configuration which are not already in
+// Files. Paths are read relative to the document fName, or to the root
+// directory when they start with "/" like a site root link, and must stay
+// inside the root directory. Files which don't exist are skipped, pandoc
+// will warn about them.
+func (cfg *Config) CollectFiles(root string, fName string, src []byte) (Files, error) {
+	return cfg.collectFiles(root, fName, src, log.Printf)
+}
+
+// collectFiles is CollectFiles with the messages about skipped files sent
+// to logf, Walk passes the job's logf so they are logged in walk order.
+func (cfg *Config) collectFiles(root string, fName string, src []byte, logf func(format string, args ...interface{})) (Files, error) {
+	links := []string{}
+	for _, m := range markdownImageRE.FindAllSubmatch(src, -1) {
+		links = append(links, string(m[1]))
+	}
+	for _, m := range htmlImageRE.FindAllSubmatch(src, -1) {
+		links = append(links, string(m[1]))
+	}
+	links = append(links, metadataPaths(src)...)
+	links = append(links, cfg.Bibliography...)
+	links = append(links, cfg.Csl, cfg.ReferenceDoc, cfg.EPubCoverImage, cfg.EPubMetadata, cfg.EPubFonts)
+
+	maxBytes := cfg.MaxResourceBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxResourceBytes
+	}
+	rootPath, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	docPath, err := filepath.Abs(fName)
+	if err != nil {
+		return nil, err
+	}
+	files, total := Files{}, int64(0)
+	for _, link := range links {
+		if !isLocalPath(link) || cfg.Files.Has(link) || files.Has(link) {
+			continue
+		}
+		name := link
+		if s, err := url.PathUnescape(link); err == nil {
+			name = s
+		}
+		var resPath string
+		switch {
+		case path.IsAbs(name):
+			// NOTE: Site root links, e.g. "/img/logo.png", are read from
+			// the top of the tree as a web server would serve them.
+			resPath = filepath.Join(rootPath, filepath.FromSlash(name))
+		case filepath.IsAbs(name) || filepath.VolumeName(name) != "":
+			if cfg.Verbose {
+				logf("%s: skipping %q, not a relative path", fName, link)
+			}
+			continue
+		default:
+			resPath = filepath.Join(filepath.Dir(docPath), filepath.FromSlash(name))
+		}
+		if !isInside(rootPath, resPath) {
+			return nil, fmt.Errorf("%q is outside of %q", link, root)
+		}
+		info, err := os.Stat(resPath)
+		if err != nil || info.IsDir() {
+			if cfg.Verbose {
+				logf("%s: skipping %q, not a file", fName, link)
+			}
+			continue
+		}
+		// NOTE: symbolic links must also point inside the root
+		if realPath, err := filepath.EvalSymlinks(resPath); err == nil {
+			if realRoot, err := filepath.EvalSymlinks(rootPath); err == nil && !isInside(realRoot, realPath) {
+				return nil, fmt.Errorf("%q is outside of %q", link, root)
+			}
+		}
+		total += info.Size()
+		if total > maxBytes {
+			return nil, fmt.Errorf("resources are larger than %d bytes", maxBytes)
+		}
+		r, err := os.Open(resPath)
+		if err != nil {
+			return nil, err
+		}
+		err = files.Add(link, r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// isInside returns true if target is root or a path inside root.
+func isInside(root string, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
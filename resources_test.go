@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the files in dName, the map is from the
+// relative path to content.
+func writeFiles(t *testing.T, dName string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		fName := filepath.Join(dName, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fName), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fName, []byte(content), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollectFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"docs/index.md": `---
+title: Resources
+bibliography:
+  - refs.bib
+  - "more.bib"
+csl: styles/chicago.csl
+---
+
+![Logo](images/logo.png "The logo") and ![remote](https://example.edu/a.png)
+
+<img class="icon" src="icon.png">
+
+![missing](images/missing.png)
+`,
+		"docs/refs.bib":           "@book{doe2022}",
+		"docs/more.bib":           "@book{roe2022}",
+		"docs/styles/chicago.csl": "<style/>",
+		"docs/images/logo.png":    "PNG",
+		"docs/icon.png":           "ICON",
+		"docs/escape.md":          "![secret](../../secret.png)",
+		"docs/big.md":             "![big](images/logo.png)",
+	})
+	cfg := &Config{}
+	fName := filepath.Join(root, "docs", "index.md")
+	src, _ := os.ReadFile(fName)
+	files, err := cfg.CollectFiles(root, fName, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := "icon.png, images/logo.png, more.bib, refs.bib, styles/chicago.csl"
+	if got := strings.Join(names, ", "); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	fName = filepath.Join(root, "docs", "escape.md")
+	src, _ = os.ReadFile(fName)
+	if _, err := cfg.CollectFiles(filepath.Join(root, "docs"), fName, src); err == nil {
+		t.Errorf("expected an error for a path outside of the root")
+	}
+
+	// Site root links are read from the top of the tree
+	writeFiles(t, root, map[string]string{
+		"posts/a.md":    "![logo](/img/logo.png) ![up](/../secret.png)",
+		"img/logo.png":  "PNG",
+		"../secret.png": "SECRET",
+	})
+	fName = filepath.Join(root, "posts", "a.md")
+	src, _ = os.ReadFile(fName)
+	if files, err := cfg.CollectFiles(root, fName, src); err == nil {
+		t.Errorf("expected an error for a site root link outside of the root")
+	} else if files != nil {
+		t.Errorf("expected no files, got %v", files)
+	}
+	writeFiles(t, root, map[string]string{"posts/a.md": "![logo](/img/logo.png)"})
+	src, _ = os.ReadFile(fName)
+	files, err = cfg.CollectFiles(root, fName, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !files.Has("/img/logo.png") {
+		t.Errorf("expected /img/logo.png to be collected, got %v", files)
+	}
+
+	fName = filepath.Join(root, "docs", "big.md")
+	src, _ = os.ReadFile(fName)
+	cfg.MaxResourceBytes = 2
+	if _, err := cfg.CollectFiles(root, fName, src); err == nil {
+		t.Errorf("expected an error for resources larger than the limit")
+	}
+}
+
+func TestWalkCollectResources(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"index.md":        "![Logo](images/logo.png)",
+		"images/logo.png": "PNG",
+	})
+	cfg := fp.config()
+	cfg.CollectResources = true
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.ReadFile(filepath.Join(root, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), "<!-- files: images/logo.png -->") {
+		t.Errorf("expected images/logo.png to be sent, got %q", src)
+	}
+	if len(cfg.Files) != 0 {
+		t.Errorf("expected cfg.Files to be left alone, got %v", cfg.Files)
+	}
+}
+
+func TestWalkCollectResourcesLog(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.md": "![missing](images/a.png)",
+		"b.md": "![missing](images/b.png)",
+		"c.md": "![missing](images/c.png)",
+	})
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	cfg := fp.config()
+	cfg.CollectResources = true
+	cfg.Verbose = true
+	cfg.Workers = 3
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	// The skipped files are logged with their document, in walk order
+	last := -1
+	for _, name := range []string{"images/a.png", "images/b.png", "images/c.png"} {
+		i := strings.Index(buf.String(), name)
+		if i < 0 {
+			t.Errorf("expected %s to be logged, got %s", name, buf)
+		}
+		if i < last {
+			t.Errorf("expected %s to be logged in walk order, got %s", name, buf)
+		}
+		last = i
+	}
+}
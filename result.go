@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Message is a log message from pandoc, e.g. a warning about a missing
+// citation or an image that could not be fetched.
+type Message struct {
+	// Verbosity is the level of the message, e.g. "ERROR", "WARNING" or "INFO"
+	Verbosity string `json:"verbosity"`
+	// Type names the kind of message, e.g. "CouldNotFetchResource"
+	Type string `json:"type"`
+	// Text is the human readable form of the message
+	Text string `json:"text"`
+}
+
+// UnmarshalJSON decodes a pandoc log message. Pandoc describes most
+// messages with type specific fields (e.g. "path" or "citation") rather
+// than text so those fields are used for Text when it is missing.
+func (msg *Message) UnmarshalJSON(src []byte) error {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(src, &fields); err != nil {
+		return err
+	}
+	text := func(key string) string {
+		val, ok := fields[key]
+		delete(fields, key)
+		if !ok || val == nil {
+			return ""
+		}
+		if s, ok := val.(string); ok {
+			return s
+		}
+		return fmt.Sprintf("%v", val)
+	}
+	msg.Verbosity = text("verbosity")
+	msg.Type = text("type")
+	msg.Text = text("text")
+	if msg.Text == "" {
+		msg.Text = text("message")
+	}
+	if msg.Text == "" && len(fields) > 0 {
+		keys := []string{}
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := []string{}
+		for _, key := range keys {
+			parts = append(parts, fmt.Sprintf("%s: %s", key, text(key)))
+		}
+		msg.Text = strings.Join(parts, ", ")
+	}
+	return nil
+}
+
+func (msg *Message) String() string {
+	return fmt.Sprintf("[%s] %s %s", msg.Verbosity, msg.Type, msg.Text)
+}
+
+// Result holds a converted document along with pandoc's log messages as
+// returned by pandoc-server when a JSON response is requested.
+type Result struct {
+	// Output is the converted document, base64 encoded output is decoded
+	Output []byte
+	// Base64 is true when pandoc-server sent the output base64 encoded,
+	// i.e. the output is a binary format like docx or epub
+	Base64 bool
+	// Messages holds pandoc's warnings and other log messages
+	Messages []*Message
+}
+
+// output is the JSON object pandoc-server returns from the root end
+// point when JSON is requested and for each document in a batch.
+type output struct {
+	Output   string     `json:"output"`
+	Base64   bool       `json:"base64"`
+	Messages []*Message `json:"messages"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// result decodes the output into a Result.
+func (o *output) result() (*Result, error) {
+	if o.Error != "" {
+		return nil, fmt.Errorf("%s", o.Error)
+	}
+	result := &Result{
+		Output:   []byte(o.Output),
+		Base64:   o.Base64,
+		Messages: o.Messages,
+	}
+	if o.Base64 {
+		src, err := base64.StdEncoding.DecodeString(o.Output)
+		if err != nil {
+			return nil, err
+		}
+		result.Output = src
+	}
+	return result, nil
+}
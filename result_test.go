@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMessageUnmarshalJSON(t *testing.T) {
+	for src, expected := range map[string]Message{
+		`{"verbosity":"WARNING","type":"CouldNotFetchResource","path":"a.png","message":"not found"}`: {
+			Verbosity: "WARNING", Type: "CouldNotFetchResource", Text: "not found",
+		},
+		`{"verbosity":"INFO","type":"Extracting","text":"media/a.png"}`: {
+			Verbosity: "INFO", Type: "Extracting", Text: "media/a.png",
+		},
+		`{"verbosity":"WARNING","type":"CiteprocWarning","citation":"doe2022","line":3}`: {
+			Verbosity: "WARNING", Type: "CiteprocWarning", Text: "citation: doe2022, line: 3",
+		},
+	} {
+		msg := new(Message)
+		if err := json.Unmarshal([]byte(src), msg); err != nil {
+			t.Error(err)
+			continue
+		}
+		if *msg != expected {
+			t.Errorf("expected %+v, got %+v", expected, msg)
+		}
+	}
+}
+
+func TestConvertResult(t *testing.T) {
+	fp := newFakePandoc(t)
+	cfg := fp.config()
+	result, err := cfg.ConvertResult(strings.NewReader("Hello WARN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<p>Hello WARN</p>"; string(result.Output) != expected {
+		t.Errorf("expected %q, got %q", expected, result.Output)
+	}
+	if result.Base64 {
+		t.Errorf("expected text output")
+	}
+	if len(result.Messages) != 1 || result.Messages[0].Verbosity != "WARNING" {
+		t.Errorf("expected a single warning, got %+v", result.Messages)
+	}
+	if cfg.Text != "" {
+		t.Errorf("expected cfg.Text to be reset, got %q", cfg.Text)
+	}
+
+	_, err = cfg.ConvertResult(strings.NewReader("FAIL"))
+	serverErr := new(ServerError)
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("expected a *ServerError, got %v", err)
+	}
+	if serverErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, serverErr.StatusCode)
+	}
+	if !strings.Contains(serverErr.Message, "could not convert") {
+		t.Errorf("expected pandoc's error message, got %q", serverErr.Message)
+	}
+}
@@ -0,0 +1,514 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walkJob is a file found by Walk along with the files it is converted to.
+type walkJob struct {
+	fName string
+	// from is the format fName is read as
+	from    string
+	outputs []*walkOutput
+	// modTime and size are used by Watch to notice changes
+	modTime time.Time
+	size    int64
+	// messages are logged once the jobs found before this one have
+	// finished so the log reads in walk order even with several workers.
+	messages []string
+	// errs holds the failures, there is at most one unless the walk
+	// continues on errors
+	errs []*FileError
+	// walkErr is set when the file, or directory, couldn't be read
+	// while walking the tree and the walk continues on errors
+	walkErr *FileError
+}
+
+// walkOutput is one of the files a walkJob is converted to.
+type walkOutput struct {
+	target  *walkTarget
+	toFName string
+	// req and entry are set by prepareJob
+	req   *request
+	entry *manifestEntry
+}
+
+// walkTarget is an output Walk produces for every file it converts,
+// there is one for each of Profiles.
+type walkTarget struct {
+	// cfg is the configuration the output is converted with
+	cfg *Config
+	// name is the name of the profile, if any
+	name string
+	ext  string
+	// perm is the permissions of the files written, zero for the default
+	perm os.FileMode
+}
+
+// walker holds the state shared by the files converted in a walk.
+type walker struct {
+	// startPath is the top of the source tree
+	startPath string
+	// outputPath is the top of the output tree, the same as startPath
+	// unless OutputDir is set
+	outputPath string
+	targets    []*walkTarget
+	// manifest is set when SkipUnchanged is set
+	manifest *manifest
+	// continueOnError is set to keep converting files after one fails
+	continueOnError bool
+}
+
+func (job *walkJob) logf(format string, args ...interface{}) {
+	job.messages = append(job.messages, fmt.Sprintf(format, args...))
+}
+
+// fail logs and records a failure converting the job's file.
+func (job *walkJob) fail(err error) {
+	fileErr, ok := err.(*FileError)
+	if !ok {
+		fileErr = newFileError(StageConvert, job.fName, "", err)
+	}
+	job.logf("%s", fileErr)
+	job.errs = append(job.errs, fileErr)
+}
+
+// Walk takes a path and walks the directories converting the files ending
+// in fromExt. If fromExt is empty the files ending in any of SourceExts,
+// or DefaultSourceExts, are converted and each is read using the format
+// its extension maps to in ExtTypes. Otherwise files are read as From if
+// set. Binary files like ".docx", ".odt" and ".epub" are always read using
+// the format their extension maps to. If CollectResources is set the
+// images and other files each document refers to are sent with it. Files
+// are converted by Workers goroutines, one at a time if Workers is not
+// set. If SkipUnchanged is set files whose source and configuration
+// haven't changed since the last walk are skipped unless Force is set.
+// Output is written next to each source file unless OutputDir is set, then
+// the directories under startPath are mirrored in OutputDir. If Profiles
+// is set each file is converted once for each profile and written using
+// the profile's extension, toExt is not used. If Prune is set the outputs
+// whose source has gone are removed once every file is converted, see
+// Orphans. Each output is written to a temporary file and renamed into
+// place so a partly written file is never seen, an output which is already
+// up to date on disk is left untouched. The walk stops at the first file
+// which fails to convert unless ContinueOnError is set, then every file is
+// tried, along with the rest of the tree when a file or directory can't be
+// read, and the failures are returned as a *WalkErrors. If DryRun is set
+// the plan is logged and nothing is converted, see Plan, the error
+// returned is for the files a real walk would fail to prepare. Files and
+// directories matching Exclude or the patterns in a .pandocignore file at
+// the top of startPath are skipped, as are files not matching Include when
+// it is set.
+func (cfg *Config) Walk(startPath string, fromExt string, toExt string) error {
+	return cfg.WalkContext(context.Background(), startPath, fromExt, toExt)
+}
+
+// WalkContext is like Walk but stops when the context is cancelled or
+// its deadline passes. The error returned names the file that was being
+// converted.
+func (cfg *Config) WalkContext(ctx context.Context, startPath string, fromExt string, toExt string) error {
+	w, err := cfg.newWalker(startPath, fromExt, toExt)
+	if err != nil {
+		return err
+	}
+	jobs, err := cfg.findJobs(ctx, w, fromExt)
+	if err != nil {
+		return err
+	}
+	if cfg.DryRun {
+		steps, err := cfg.plan(ctx, w, jobs)
+		for _, step := range steps {
+			log.Print(step)
+		}
+		if err != nil {
+			return err
+		}
+		return planError(w, jobs)
+	}
+	if err := cfg.runJobs(ctx, w, jobs); err != nil {
+		return err
+	}
+	if cfg.Prune {
+		orphans, err := cfg.findOrphans(ctx, w, jobs)
+		if err != nil {
+			return err
+		}
+		return cfg.pruneOrphans(w, orphans)
+	}
+	return nil
+}
+
+// newWalker sets up a walk of startPath reading files ending in fromExt
+// and writing files with the extension toExt, or the extensions of
+// Profiles. Prune is rejected without SkipUnchanged since only the
+// outputs in the manifest are ever pruned.
+func (cfg *Config) newWalker(startPath string, fromExt string, toExt string) (*walker, error) {
+	if cfg.Prune && !cfg.SkipUnchanged {
+		return nil, errPruneManifest
+	}
+	w := &walker{startPath: startPath, outputPath: startPath, continueOnError: cfg.ContinueOnError}
+	if cfg.OutputDir != "" {
+		w.outputPath = cfg.OutputDir
+	}
+	var err error
+	if w.targets, err = cfg.walkTargets(fromExt, toExt); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// findJobs walks the source tree and returns the files to convert, in
+// walk order, along with the files each is converted to. If the walk
+// continues on errors a file or directory which can't be read is
+// returned as a job which fails, otherwise the walk stops.
+func (cfg *Config) findJobs(ctx context.Context, w *walker, fromExt string) ([]*walkJob, error) {
+	startPath := w.startPath
+	outputPath, err := filepath.Abs(w.outputPath)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := cfg.newWalkFilter(startPath)
+	if err != nil {
+		return nil, err
+	}
+	formats, err := cfg.sourceFormats(fromExt)
+	if err != nil {
+		return nil, err
+	}
+	jobs, outputs := []*walkJob{}, map[string]string{}
+	err = filepath.Walk(startPath,
+		func(fName string, info fs.FileInfo, walkErr error) error {
+			if walkErr != nil && (!w.continueOnError || fName == startPath) {
+				return walkErr
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(startPath, fName)
+			if err != nil {
+				return err
+			}
+			// NOTE: When the walk continues on errors an entry which
+			// can't be read is reported with the files which failed to
+			// convert and the rest of the tree is still walked.
+			if info != nil && info.IsDir() {
+				if rel == "." {
+					return nil
+				}
+				// NOTE: An output tree inside the source tree is not walked.
+				if absName, err := filepath.Abs(fName); err == nil && absName == outputPath && w.outputPath != startPath {
+					return filepath.SkipDir
+				}
+				if filter.excluded(rel, true) {
+					return filepath.SkipDir
+				}
+				if walkErr != nil {
+					jobs = append(jobs, &walkJob{fName: fName, walkErr: newFileError(StageRead, fName, "", walkErr)})
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			ext := path.Ext(fName)
+			from, ok := formats[strings.ToLower(ext)]
+			if ok && filepath.Base(fName) != ManifestName &&
+				filter.included(rel) && !filter.excluded(rel, false) {
+				job := &walkJob{fName: fName, from: from}
+				if walkErr != nil {
+					job.walkErr = newFileError(StageRead, fName, "", walkErr)
+				} else {
+					job.modTime, job.size = info.ModTime(), info.Size()
+				}
+				for _, target := range w.targets {
+					toFName := filepath.Join(w.outputPath, strings.TrimSuffix(rel, ext)+target.ext)
+					if other, ok := outputs[toFName]; ok {
+						return fmt.Errorf("%q and %q both convert to %q", other, fName, toFName)
+					}
+					outputs[toFName] = fName
+					job.outputs = append(job.outputs, &walkOutput{target: target, toFName: toFName})
+				}
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// sourceFormats maps the extensions Walk converts to the format each is
+// read as.
+func (cfg *Config) sourceFormats(fromExt string) (map[string]string, error) {
+	formats := map[string]string{}
+	if fromExt != "" {
+		// NOTE: Binary formats can only be read as themselves so
+		// From is set by the extension, e.g. ".docx" is read as docx.
+		format, ok := cfg.extType(fromExt)
+		if cfg.From != "" && !(ok && isBinaryFormat(format)) {
+			format = cfg.From
+		}
+		formats[strings.ToLower(fromExt)] = format
+		return formats, nil
+	}
+	exts := cfg.SourceExts
+	if len(exts) == 0 {
+		exts = DefaultSourceExts
+	}
+	for _, ext := range exts {
+		format, ok := cfg.extType(ext)
+		if !ok {
+			return nil, fmt.Errorf("no format for %q, add it to ext_types", ext)
+		}
+		formats[strings.ToLower(ext)] = format
+	}
+	return formats, nil
+}
+
+// walkTargets returns the outputs produced for each file, one for each
+// of Profiles or a single one with the extension toExt. The files read
+// end in fromExt, see checkProfiles. An error is returned if FileMode
+// isn't a valid file mode.
+func (cfg *Config) walkTargets(fromExt string, toExt string) ([]*walkTarget, error) {
+	if len(cfg.Profiles) == 0 {
+		if err := checkOutputFormat(cfg.To); err != nil {
+			return nil, err
+		}
+		perm, err := cfg.fileMode()
+		if err != nil {
+			return nil, err
+		}
+		return []*walkTarget{{cfg: cfg, ext: toExt, perm: perm}}, nil
+	}
+	if err := cfg.checkProfiles(fromExt); err != nil {
+		return nil, err
+	}
+	targets := []*walkTarget{}
+	for _, profile := range cfg.Profiles {
+		pcfg, err := cfg.profileConfig(profile)
+		if err != nil {
+			return nil, err
+		}
+		perm, err := pcfg.fileMode()
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %s", profile.Name, err)
+		}
+		targets = append(targets, &walkTarget{cfg: pcfg, name: profile.Name, ext: profile.Ext, perm: perm})
+	}
+	return targets, nil
+}
+
+// runJobs converts the files found by Walk using a pool of Workers
+// goroutines. Messages are logged in walk order and the error returned
+// is from the first file, in walk order, that failed. No new files are
+// started once a file has failed unless the walk continues on errors,
+// then every file is converted and the failures are returned as a
+// *WalkErrors. When SkipUnchanged is set the manifest is saved with the
+// files converted so far.
+func (cfg *Config) runJobs(ctx context.Context, w *walker, jobs []*walkJob) error {
+	if cfg.SkipUnchanged {
+		w.manifest = loadManifest(w.outputPath)
+	}
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queue, done, stop := make(chan int), make(chan int), make(chan struct{})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				cfg.convertJob(ctx, w, jobs[i])
+				done <- i
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		for i := range jobs {
+			select {
+			case queue <- i:
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var firstErr error
+	report := &WalkErrors{}
+	finished, next, stopped := make([]bool, len(jobs)), 0, false
+	for i := range done {
+		finished[i] = true
+		if len(jobs[i].errs) > 0 && !stopped && !w.continueOnError {
+			close(stop)
+			stopped = true
+		}
+		for next < len(jobs) && finished[next] {
+			for _, msg := range jobs[next].messages {
+				log.Print(msg)
+			}
+			report.Files++
+			report.Errors = append(report.Errors, jobs[next].errs...)
+			next++
+		}
+	}
+	if len(report.Errors) > 0 {
+		firstErr = report.Errors[0]
+		if w.continueOnError {
+			firstErr = report
+		}
+	}
+	if w.manifest != nil {
+		if err := w.manifest.save(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// prepareJob reads a file found by Walk, collecting its resources when
+// CollectResources is set, and builds the request and manifest entry for
+// each of its outputs. The file is read once however many outputs it has.
+func (cfg *Config) prepareJob(w *walker, job *walkJob) error {
+	if job.walkErr != nil {
+		return job.walkErr
+	}
+	fName := job.fName
+	src, err := os.ReadFile(fName)
+	if err != nil {
+		return newFileError(StageRead, fName, "", err)
+	}
+	from := job.from
+	var files Files
+	if cfg.CollectResources && !isBinaryFormat(from) {
+		files, err = cfg.collectFiles(w.startPath, fName, src, job.logf)
+		if err != nil {
+			return newFileError(StageResources, fName, "", err)
+		}
+	}
+	for _, out := range job.outputs {
+		out.req = out.target.cfg.newRequest(src, from, files)
+		out.entry, err = newManifestEntry(w.startPath, fName, src, out.req)
+		if err != nil {
+			return newFileError(StagePrepare, fName, out.toFName, err)
+		}
+	}
+	return nil
+}
+
+// convertJob converts and writes the outputs of a single file found by
+// Walk. If the walk has a manifest outputs which haven't changed since
+// they were last converted are skipped. The remaining outputs are only
+// tried after one fails if the walk continues on errors.
+func (cfg *Config) convertJob(ctx context.Context, w *walker, job *walkJob) {
+	if err := cfg.prepareJob(w, job); err != nil {
+		job.fail(err)
+		return
+	}
+	for _, out := range job.outputs {
+		if err := cfg.convertOutput(ctx, w, job, out); err != nil {
+			job.fail(err)
+			if !w.continueOnError {
+				return
+			}
+		}
+	}
+}
+
+// upToDate returns true if the manifest shows the output was made from
+// the same source and configuration and the output is still there.
+func (cfg *Config) upToDate(w *walker, output string, out *walkOutput) bool {
+	if w.manifest == nil || cfg.Force || !w.manifest.unchanged(output, out.entry) {
+		return false
+	}
+	_, err := os.Stat(out.toFName)
+	return err == nil
+}
+
+// convertOutput converts and writes one output of a file found by Walk
+// and updates the manifest.
+func (cfg *Config) convertOutput(ctx context.Context, w *walker, job *walkJob, out *walkOutput) error {
+	fName, m := job.fName, w.manifest
+	output, err := filepath.Rel(w.outputPath, out.toFName)
+	if err != nil {
+		return newFileError(StagePrepare, fName, out.toFName, err)
+	}
+	output = filepath.ToSlash(output)
+	if cfg.upToDate(w, output, out) {
+		if cfg.Verbose {
+			job.logf("skip %q, %q is up to date", fName, out.toFName)
+		}
+		return nil
+	}
+	var (
+		fileErr *FileError
+		written bool
+	)
+	result, err := out.target.cfg.convert(ctx, out.req)
+	if err == nil {
+		for _, msg := range result.Messages {
+			if cfg.Verbose || msg.Verbosity != "INFO" {
+				job.logf("%s: %s", fName, msg)
+			}
+		}
+		written, err = writeFileAtomic(out.toFName, result.Output, out.target.perm)
+		if err != nil {
+			fileErr = newFileError(StageWrite, fName, out.toFName, err)
+		}
+	} else {
+		fileErr = newFileError(StageConvert, fName, out.toFName, err)
+	}
+	if fileErr != nil {
+		if m != nil {
+			m.remove(output)
+		}
+		return fileErr
+	}
+	if m != nil {
+		m.set(output, out.entry)
+	}
+	if cfg.Verbose {
+		switch {
+		case !written:
+			job.logf("convert %q, %q is unchanged", fName, out.toFName)
+		case out.target.name != "":
+			job.logf("convert %q to %q using %s", fName, out.toFName, out.target.name)
+		default:
+			job.logf("convert %q to %q", fName, out.toFName)
+		}
+	}
+	return nil
+}
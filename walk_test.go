@@ -0,0 +1,410 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWalkWorkers(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("section%d/page%02d.md", i%5, i)] = fmt.Sprintf("page %d", i)
+	}
+	writeFiles(t, root, files)
+
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	cfg := fp.config()
+	cfg.Workers = 8
+	cfg.Verbose = true
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range files {
+		fName := filepath.Join(root, strings.TrimSuffix(name, ".md")+".html")
+		src, err := os.ReadFile(fName)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if expected := "<p>" + text + "</p>"; string(src) != expected {
+			t.Errorf("%s: expected %q, got %q", fName, expected, src)
+		}
+	}
+	// The log should be in walk order
+	last := ""
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if i := strings.Index(line, "convert "); i > -1 {
+			if line[i:] < last {
+				t.Errorf("expected %q to be logged before %q", line[i:], last)
+			}
+			last = line[i:]
+		}
+	}
+}
+
+func TestWalkWorkersError(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.md": "one",
+		"b.md": "FAIL two",
+		"c.md": "FAIL three",
+		"d.md": "four",
+	})
+	cfg := fp.config()
+	cfg.Workers = 4
+	err := cfg.Walk(root, ".md", ".html")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if fName := filepath.Join(root, "b.md"); !strings.Contains(err.Error(), fName) {
+		t.Errorf("expected the error to name %q, got %q", fName, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a.html")); err != nil {
+		t.Errorf("expected a.md to be converted, %s", err)
+	}
+}
+
+func TestWalkOutputDir(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"index.md":        "home",
+		"a/b/page.md":     "nested",
+		"a/readme.txt":    "not converted",
+		"htdocs/stale.md": "inside the output tree",
+	})
+	outDir := filepath.Join(root, "htdocs")
+	cfg := fp.config()
+	cfg.OutputDir = outDir
+	cfg.SkipUnchanged = true
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"index.html":    "<p>home</p>",
+		"a/b/page.html": "<p>nested</p>",
+	} {
+		src, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(src) != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, src)
+		}
+	}
+	for _, name := range []string{"index.html", "a/b/page.html", "htdocs/stale.html"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%s should not have been written", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, ManifestName)); err != nil {
+		t.Errorf("expected the manifest in the output directory, %s", err)
+	}
+}
+
+func TestWalkSourceExts(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	doc, err := fakeConvert(map[string]interface{}{"text": "deposit", "to": "docx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{
+		"a.md":         "FROM a",
+		"b.markdown":   "FROM b",
+		"c.rst":        "FROM c",
+		"d/e.org":      "FROM e",
+		"d/f.tex":      "FROM f",
+		"d/g.ipynb":    "FROM g",
+		"h.docx":       string(doc),
+		"notes.txt":    "not a source",
+		"custom.mdown": "FROM custom",
+		"upper.MD":     "FROM upper",
+	})
+	cfg := fp.config()
+	cfg.From = "commonmark"
+	if err := cfg.Walk(root, "", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"a.html":     "<p>FROM a</p><!-- from: markdown -->",
+		"b.html":     "<p>FROM b</p><!-- from: markdown -->",
+		"c.html":     "<p>FROM c</p><!-- from: rst -->",
+		"d/e.html":   "<p>FROM e</p><!-- from: org -->",
+		"d/f.html":   "<p>FROM f</p><!-- from: latex -->",
+		"d/g.html":   "<p>FROM g</p><!-- from: ipynb -->",
+		"h.html":     "<p><p>deposit</p></p>",
+		"upper.html": "<p>FROM upper</p><!-- from: markdown -->",
+	} {
+		src, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(src) != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, src)
+		}
+	}
+	for _, name := range []string{"notes.html", "custom.html"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%s should not have been converted", name)
+		}
+	}
+
+	// A single extension walk reads the files as From
+	if err := cfg.Walk(root, ".mdown", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := os.ReadFile(filepath.Join(root, "custom.html"))
+	if expected := "<p>FROM custom</p><!-- from: commonmark -->"; string(src) != expected {
+		t.Errorf("expected %q, got %q", expected, src)
+	}
+
+	// Source extensions and their formats come from the configuration
+	cfg.SourceExts = []string{".txt"}
+	cfg.ExtTypes = map[string]string{".TXT": "commonmark"}
+	if err := cfg.Walk(root, "", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "notes.html")); err != nil {
+		t.Error(err)
+	}
+	cfg.SourceExts = []string{".unknown"}
+	if err := cfg.Walk(root, "", ".html"); err == nil {
+		t.Errorf("expected an error for an extension without a format")
+	}
+
+	// Two sources can't be written to the same output
+	writeFiles(t, root, map[string]string{"a.rst": "clash"})
+	cfg.SourceExts = nil
+	if err := cfg.Walk(root, "", ".html"); err == nil {
+		t.Errorf("expected an error for a.md and a.rst")
+	}
+}
+
+func TestWalkProfiles(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"article.md":      "article",
+		"news/brief.md":   "brief",
+		"news/ignored.md": "FAIL if converted",
+	})
+	cfg := fp.config()
+	cfg.Exclude = []string{"ignored.md"}
+	cfg.SkipUnchanged = true
+	cfg.Profiles = []*Profile{
+		{Name: "web", To: "html5", Ext: ".html"},
+		{Name: "print", To: "latex", Ext: ".tex", Options: []byte(`{"standalone": true}`)},
+		{Name: "word", To: "docx", Ext: ".docx"},
+		{Name: "ebook", To: "epub", Ext: ".epub"},
+	}
+	to := cfg.To
+	if err := cfg.Walk(root, ".md", ".unused"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fp.converts); n != 8 {
+		t.Errorf("expected 8 conversions, got %d", n)
+	}
+	for _, name := range []string{"article", "news/brief"} {
+		for _, ext := range []string{".html", ".tex"} {
+			src, err := os.ReadFile(filepath.Join(root, name+ext))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if expected := "<p>" + filepath.Base(name) + "</p>"; string(src) != expected {
+				t.Errorf("%s%s: expected %q, got %q", name, ext, expected, src)
+			}
+		}
+		for ext, entry := range map[string]string{".docx": "word/document.xml", ".epub": "mimetype"} {
+			fName := filepath.Join(root, name+ext)
+			src, err := os.ReadFile(fName)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			checkZip(t, fName, src, entry)
+		}
+		if _, err := os.Stat(filepath.Join(root, name+".unused")); err == nil {
+			t.Errorf("%s.unused should not have been written", name)
+		}
+	}
+	if cfg.To != to || cfg.Standalone {
+		t.Errorf("the profiles should not change the configuration")
+	}
+
+	// Every output is up to date on the second walk
+	if err := cfg.Walk(root, ".md", ".unused"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fp.converts); n != 8 {
+		t.Errorf("expected no more conversions, got %d", n-8)
+	}
+
+	cfg.Profiles = append(cfg.Profiles, &Profile{Name: "web", Ext: ".htm"})
+	if err := cfg.Walk(root, ".md", ".unused"); err == nil {
+		t.Errorf("expected an error for a duplicate profile name")
+	}
+}
+
+func TestWalkContinueOnError(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.md": "one",
+		"b.md": "FAIL two",
+		"c.md": "![escape](../../secret.png)",
+		"d.md": "four",
+		"e.md": "FAIL five",
+		"f.md": "six",
+	})
+	cfg := fp.config()
+	cfg.Workers = 3
+	cfg.CollectResources = true
+	cfg.ContinueOnError = true
+	err := cfg.Walk(root, ".md", ".html")
+	var report *WalkErrors
+	if !errors.As(err, &report) {
+		t.Fatalf("expected a *WalkErrors, got %T %v", err, err)
+	}
+	if report.Files != 6 || report.Failed() != 3 {
+		t.Errorf("expected 3 of 6 files to fail, got %s", report.Summary())
+	}
+	if !strings.HasPrefix(err.Error(), "3 of 6 files failed to convert\n") {
+		t.Errorf("expected the error to start with the summary, got %q", err)
+	}
+	expected := []struct {
+		name       string
+		stage      string
+		statusCode int
+		message    string
+	}{
+		{"b.md", StageConvert, http.StatusInternalServerError, `could not convert "FAIL two"`},
+		{"c.md", StageResources, 0, ""},
+		{"e.md", StageConvert, http.StatusInternalServerError, `could not convert "FAIL five"`},
+	}
+	if len(report.Errors) != len(expected) {
+		t.Fatalf("expected %d errors, got %d", len(expected), len(report.Errors))
+	}
+	for i, e := range expected {
+		fileErr := report.Errors[i]
+		if fileErr.Path != filepath.Join(root, e.name) || fileErr.Stage != e.stage ||
+			fileErr.StatusCode != e.statusCode || fileErr.Message != e.message {
+			t.Errorf("expected %s to fail at %s with %d %q, got %+v", e.name, e.stage, e.statusCode, e.message, fileErr)
+		}
+	}
+	var serverErr *ServerError
+	if !errors.As(report.Errors[0], &serverErr) {
+		t.Errorf("expected the conversion error to wrap a *ServerError")
+	}
+	// errors.As looks at each failure in the report
+	if !errors.As(err, &serverErr) || serverErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected to find the *ServerError through the *WalkErrors")
+	}
+	for _, name := range []string{"a.html", "d.html", "f.html"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("expected %s to be converted, %s", name, err)
+		}
+	}
+
+	// Without ContinueOnError the first failure is returned
+	cfg.ContinueOnError = false
+	cfg.Workers = 1
+	err = cfg.Walk(root, ".md", ".html")
+	var fileErr *FileError
+	if !errors.As(err, &fileErr) || fileErr.Path != filepath.Join(root, "b.md") {
+		t.Errorf("expected a *FileError for b.md, got %v", err)
+	}
+
+	// Nothing is reported when every file converts
+	for _, name := range []string{"b.md", "c.md", "e.md"} {
+		os.Remove(filepath.Join(root, name))
+	}
+	cfg.ContinueOnError = true
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestWalkContinueOnUnreadableDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions aren't enforced for root")
+	}
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.md":         "one",
+		"private/b.md": "two",
+		"z.md":         "three",
+	})
+	private := filepath.Join(root, "private")
+	if err := os.Chmod(private, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(private, 0775) })
+	cfg := fp.config()
+	if err := cfg.Walk(root, ".md", ".html"); err == nil {
+		t.Errorf("expected the unreadable directory to stop the walk")
+	}
+
+	// One unreadable directory doesn't stop the rest of the tree
+	cfg.ContinueOnError = true
+	err := cfg.Walk(root, ".md", ".html")
+	var report *WalkErrors
+	if !errors.As(err, &report) {
+		t.Fatalf("expected a *WalkErrors, got %T %v", err, err)
+	}
+	if report.Failed() != 1 || report.Errors[0].Path != private || report.Errors[0].Stage != StageRead {
+		t.Errorf("expected %s to fail at %s, got %+v", private, StageRead, report.Errors)
+	}
+	for _, name := range []string{"a.html", "z.html"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("expected %s to be converted, %s", name, err)
+		}
+	}
+	if _, err := cfg.Orphans(root, ".md", ".html"); err == nil {
+		t.Errorf("expected no orphans to be found when part of the tree can't be read")
+	}
+}
+
+func TestDefaultExtTypes(t *testing.T) {
+	// Every format Walk may read must be one pandoc can read
+	for ext, format := range DefaultExtTypes {
+		if inStringList(format, []string{"pdf", "plain", "html4", "asciidoc"}) {
+			t.Errorf("%s maps to %s which pandoc can't read", ext, format)
+		}
+	}
+	cfg := &Config{}
+	if format, ok := cfg.extType(".Markdown"); !ok || format != "markdown" {
+		t.Errorf("expected .Markdown to be read as markdown, got %q", format)
+	}
+}
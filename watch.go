@@ -0,0 +1,246 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultPollInterval is how often, in milliseconds, Watch looks for
+	// changed files.
+	DefaultPollInterval = 1000
+	// DefaultDebounce is how long, in milliseconds, the files must stop
+	// changing before Watch converts them.
+	DefaultDebounce = 500
+	// MaxRetryDelay is the longest Watch waits before trying a file
+	// which failed to convert again.
+	MaxRetryDelay = time.Minute
+)
+
+// Watch converts the files like Walk then keeps polling the directories
+// for files which are created, modified, renamed or deleted. Once the
+// changes have settled for Debounce milliseconds the changed files are
+// converted and the outputs of deleted files are removed. The tree is
+// polled every PollInterval milliseconds so no platform specific file
+// notification is needed. Errors converting files are logged and Watch
+// carries on with the other files. A file which failed because
+// pandoc-server couldn't be reached or failed itself is tried again,
+// waiting twice as long after each failure up to MaxRetryDelay, other
+// failures wait for the file to change. If DryRun is set the plan for
+// each change is logged and nothing is converted or removed. Watch
+// returns nil when the context is cancelled.
+func (cfg *Config) Watch(ctx context.Context, startPath string, fromExt string, toExt string) error {
+	w, err := cfg.newWalker(startPath, fromExt, toExt)
+	if err != nil {
+		return err
+	}
+	// NOTE: One bad file shouldn't stop the others being converted.
+	w.continueOnError = true
+	jobs, err := cfg.findJobs(ctx, w, fromExt)
+	if err != nil {
+		return err
+	}
+	interval := time.Duration(cfg.PollInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = DefaultPollInterval * time.Millisecond
+	}
+	debounce := time.Duration(cfg.Debounce) * time.Millisecond
+	if debounce <= 0 {
+		debounce = DefaultDebounce * time.Millisecond
+	}
+	// retries holds the files to try again after a transient failure
+	retries := map[string]*watchRetry{}
+	cfg.watchJobs(ctx, w, jobs)
+	scheduleRetries(retries, jobs, interval)
+	known := map[string]*walkJob{}
+	for _, job := range jobs {
+		known[job.fName] = job
+	}
+	// changed and deleted hold the files seen to change since the last
+	// conversion, lastChange is when the most recent change was seen.
+	changed, deleted := map[string]*walkJob{}, map[string]*walkJob{}
+	lastChange := time.Time{}
+	if cfg.Verbose {
+		log.Printf("watching %q", startPath)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		jobs, err := cfg.findJobs(ctx, w, fromExt)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("%s", err)
+			continue
+		}
+		current := map[string]*walkJob{}
+		for _, job := range jobs {
+			current[job.fName] = job
+			prev, ok := known[job.fName]
+			if !ok || !prev.modTime.Equal(job.modTime) || prev.size != job.size {
+				changed[job.fName] = job
+				delete(deleted, job.fName)
+				lastChange = time.Now()
+			}
+		}
+		for fName, job := range known {
+			if _, ok := current[fName]; !ok {
+				deleted[fName] = job
+				delete(changed, fName)
+				delete(retries, fName)
+				lastChange = time.Now()
+			}
+		}
+		known = current
+		now := time.Now()
+		settled := now.Sub(lastChange) >= debounce
+		pending := []*walkJob{}
+		for _, job := range jobs {
+			_, isChanged := changed[job.fName]
+			retry, isRetry := retries[job.fName]
+			if (isChanged && settled) || (!isChanged && isRetry && !now.Before(retry.next)) {
+				pending = append(pending, job)
+			}
+		}
+		if settled {
+			cfg.removeOutputs(w, deleted)
+			changed, deleted = map[string]*walkJob{}, map[string]*walkJob{}
+		}
+		if len(pending) > 0 {
+			cfg.watchJobs(ctx, w, pending)
+			scheduleRetries(retries, pending, interval)
+		}
+	}
+}
+
+// watchRetry records when Watch next tries a file which failed.
+type watchRetry struct {
+	attempts int
+	next     time.Time
+}
+
+// scheduleRetries works out when each of jobs which failed for a reason
+// that may pass is tried again, the delay starts at interval and doubles
+// with each failure. Jobs which converted, or failed in a way only
+// changing the file can fix, are dropped.
+func scheduleRetries(retries map[string]*watchRetry, jobs []*walkJob, interval time.Duration) {
+	for _, job := range jobs {
+		if !failedTransiently(job) {
+			delete(retries, job.fName)
+			continue
+		}
+		retry, ok := retries[job.fName]
+		if !ok {
+			retry = &watchRetry{}
+			retries[job.fName] = retry
+		}
+		delay := MaxRetryDelay
+		if retry.attempts < 16 && interval<<retry.attempts < MaxRetryDelay {
+			delay = interval << retry.attempts
+		}
+		retry.attempts++
+		retry.next = time.Now().Add(delay)
+	}
+}
+
+// failedTransiently returns true if the job failed because pandoc-server
+// couldn't be reached, timed out or answered with a 5xx status.
+func failedTransiently(job *walkJob) bool {
+	for _, e := range job.errs {
+		if e.Stage == StageConvert && (e.StatusCode == 0 || e.StatusCode >= 500) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchJobs converts jobs, or logs the plan for them when DryRun is set.
+// Each failure is logged as its file is converted so only the summary
+// of a *WalkErrors is logged.
+func (cfg *Config) watchJobs(ctx context.Context, w *walker, jobs []*walkJob) {
+	if cfg.DryRun {
+		steps, err := cfg.planJobs(ctx, w, jobs)
+		for _, step := range steps {
+			log.Print(step)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("%s", err)
+		}
+		return
+	}
+	err := cfg.runJobs(ctx, w, jobs)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	var report *WalkErrors
+	if errors.As(err, &report) {
+		log.Print(report.Summary())
+		return
+	}
+	log.Printf("%s", err)
+}
+
+// removeOutputs removes the files converted from sources which have been
+// deleted and drops them from the manifest.
+func (cfg *Config) removeOutputs(w *walker, deleted map[string]*walkJob) {
+	if len(deleted) == 0 {
+		return
+	}
+	if cfg.DryRun {
+		for fName, job := range deleted {
+			for _, out := range job.outputs {
+				log.Print(&PlanStep{Action: "remove", Source: fName, Output: out.toFName, Reason: "source deleted"})
+			}
+		}
+		return
+	}
+	var m *manifest
+	if cfg.SkipUnchanged {
+		m = loadManifest(w.outputPath)
+	}
+	for fName, job := range deleted {
+		for _, out := range job.outputs {
+			if err := os.Remove(out.toFName); err != nil && !os.IsNotExist(err) {
+				log.Printf("%s", err)
+				continue
+			}
+			if m != nil {
+				if output, err := filepath.Rel(w.outputPath, out.toFName); err == nil {
+					m.remove(filepath.ToSlash(output))
+				}
+			}
+			if cfg.Verbose {
+				log.Printf("remove %q, %q was deleted", out.toFName, fName)
+			}
+		}
+	}
+	if m != nil {
+		if err := m.save(); err != nil {
+			log.Printf("%s", err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls until ok returns true or fails the test after a few
+// seconds.
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if ok() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestWatch(t *testing.T) {
+	fp := newFakePandoc(t)
+	root, outDir := t.TempDir(), t.TempDir()
+	writeFiles(t, root, map[string]string{"a.md": "one"})
+	cfg := fp.config()
+	cfg.OutputDir = outDir
+	cfg.SkipUnchanged = true
+	cfg.PollInterval = 10
+	cfg.Debounce = 30
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- cfg.Watch(ctx, root, ".md", ".html")
+	}()
+	hasContent := func(name string, expected string) func() bool {
+		return func() bool {
+			src, err := os.ReadFile(filepath.Join(outDir, name))
+			return err == nil && string(src) == expected
+		}
+	}
+	isMissing := func(name string) func() bool {
+		return func() bool {
+			_, err := os.Stat(filepath.Join(outDir, name))
+			return os.IsNotExist(err)
+		}
+	}
+	waitFor(t, "the first walk", hasContent("a.html", "<p>one</p>"))
+
+	// Modified and created files are converted
+	writeFiles(t, root, map[string]string{"a.md": "one again", "sub/b.md": "two"})
+	waitFor(t, "a.md to be reconverted", hasContent("a.html", "<p>one again</p>"))
+	waitFor(t, "sub/b.md to be converted", hasContent("sub/b.html", "<p>two</p>"))
+
+	// Renaming removes the old output and converts the new name
+	if err := os.Rename(filepath.Join(root, "sub/b.md"), filepath.Join(root, "sub/c.md")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "sub/c.md to be converted", hasContent("sub/c.html", "<p>two</p>"))
+	waitFor(t, "sub/b.html to be removed", isMissing("sub/b.html"))
+
+	// Deleting a source removes its output and its manifest entry
+	if err := os.Remove(filepath.Join(root, "a.md")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a.html to be removed", isMissing("a.html"))
+	waitFor(t, "the manifest to be updated", func() bool {
+		m := loadManifest(outDir)
+		_, ok := m.Outputs["a.html"]
+		return !ok
+	})
+
+	// Bursts of saves are converted once they settle
+	before := atomic.LoadInt32(&fp.converts)
+	for i := 0; i < 5; i++ {
+		writeFiles(t, root, map[string]string{"sub/c.md": "draft " + string(rune('a'+i))})
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitFor(t, "the last save to be converted", hasContent("sub/c.html", "<p>draft e</p>"))
+	if n := atomic.LoadInt32(&fp.converts) - before; n > 2 {
+		t.Errorf("expected the burst of saves to be debounced, got %d conversions", n)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not stop when the context was cancelled")
+	}
+}
+
+func TestWatchRetry(t *testing.T) {
+	fp := newFakePandoc(t)
+	root, outDir := t.TempDir(), t.TempDir()
+	writeFiles(t, root, map[string]string{"a.md": "FAIL", "b.md": "two"})
+	cfg := fp.config()
+	cfg.OutputDir = outDir
+	cfg.PollInterval = 10
+	cfg.Debounce = 30
+	atomic.StoreInt32(&fp.down, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cfg.Watch(ctx, root, ".md", ".html")
+	hasContent := func(name string, expected string) func() bool {
+		return func() bool {
+			src, err := os.ReadFile(filepath.Join(outDir, name))
+			return err == nil && string(src) == expected
+		}
+	}
+
+	// Files failing while the server is down are converted once it is
+	// back without being touched
+	waitFor(t, "the first walk to fail", func() bool {
+		return atomic.LoadInt32(&fp.converts) >= 2
+	})
+	atomic.StoreInt32(&fp.down, 0)
+	waitFor(t, "b.md to be retried", hasContent("b.html", "<p>two</p>"))
+
+	// A file which can't be converted doesn't hold up the others
+	writeFiles(t, root, map[string]string{"c.md": "three"})
+	waitFor(t, "c.md to be converted", hasContent("c.html", "<p>three</p>"))
+	writeFiles(t, root, map[string]string{"a.md": "one"})
+	waitFor(t, "a.md to be converted once fixed", hasContent("a.html", "<p>one</p>"))
+}
+
+func TestWatchRetryBackoff(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.md": "FAIL"})
+	cfg := fp.config()
+	cfg.PollInterval = 10
+	cfg.Debounce = 30
+
+	buf := new(lockedBuffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- cfg.Watch(ctx, root, ".md", ".html")
+	}()
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	<-done
+
+	// Polling every 10ms without a backoff would convert it 50 times
+	n := atomic.LoadInt32(&fp.converts)
+	if n < 2 || n > 8 {
+		t.Errorf("expected a few tries with a backoff, got %d conversions", n)
+	}
+	// Each failure is logged once
+	if logged := strings.Count(buf.String(), `could not convert "FAIL"`); logged != int(n) {
+		t.Errorf("expected %d failures to be logged, got %d in %s", n, logged, buf)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer which can be logged to while it is read.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
+
+func TestWatchDryRun(t *testing.T) {
+	fp := newFakePandoc(t)
+	root, outDir := t.TempDir(), t.TempDir()
+	writeFiles(t, root, map[string]string{"a.md": "one"})
+	cfg := fp.config()
+	cfg.OutputDir = outDir
+	cfg.DryRun = true
+	cfg.PollInterval = 10
+	cfg.Debounce = 30
+
+	buf := new(lockedBuffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- cfg.Watch(ctx, root, ".md", ".html")
+	}()
+	logged := func(text string) func() bool {
+		return func() bool {
+			return strings.Contains(buf.String(), text)
+		}
+	}
+	waitFor(t, "the plan for a.md", logged(`convert "`+filepath.Join(root, "a.md")))
+	writeFiles(t, root, map[string]string{"b.md": "two"})
+	waitFor(t, "the plan for b.md", logged(`convert "`+filepath.Join(root, "b.md")))
+	if err := os.Remove(filepath.Join(root, "a.md")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the plan to remove a.html", logged(`remove "`+filepath.Join(outDir, "a.html")))
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&fp.converts); n != 0 {
+		t.Errorf("expected nothing to be converted, got %d conversions", n)
+	}
+	if entries, _ := os.ReadDir(outDir); len(entries) != 0 {
+		t.Errorf("expected nothing to be written, got %d files", len(entries))
+	}
+}
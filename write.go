@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DefaultFileMode is the permissions, less the umask, of the files Walk
+// writes when FileMode is not set.
+const DefaultFileMode os.FileMode = 0664
+
+// parseFileMode parses an octal permission string like "0644".
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode == 0 || mode > 0777 {
+		return 0, fmt.Errorf("%q is not an octal file mode like \"0644\"", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// fileMode returns the permissions for the files Walk writes, zero if
+// FileMode is not set so the umask is honoured, see writeFileAtomic.
+func (cfg *Config) fileMode() (os.FileMode, error) {
+	if cfg.FileMode == "" {
+		return 0, nil
+	}
+	mode, err := parseFileMode(cfg.FileMode)
+	if err != nil {
+		return 0, fmt.Errorf("file_mode: %s", err)
+	}
+	return mode, nil
+}
+
+// writeFileAtomic replaces fName with src so that readers see either
+// the old file or the new one, never a partial file. The new content is
+// written to a temporary file in the same directory, synced to disk and
+// renamed over fName. If fName already holds src it is left untouched,
+// keeping its modification time, and false is returned. The file gets
+// the permissions perm. When perm is zero the file behaves as with
+// os.WriteFile, an existing file keeps its permissions and a new one is
+// created with DefaultFileMode less the umask.
+func writeFileAtomic(fName string, src []byte, perm os.FileMode) (bool, error) {
+	info, err := os.Stat(fName)
+	exists := err == nil && info.Mode().IsRegular()
+	if exists && info.Size() == int64(len(src)) {
+		if old, err := os.ReadFile(fName); err == nil && bytes.Equal(old, src) {
+			if perm != 0 && info.Mode().Perm() != perm {
+				return false, os.Chmod(fName, perm)
+			}
+			return false, nil
+		}
+	}
+	if perm == 0 && exists {
+		// NOTE: The temporary file replaces fName so it must carry over
+		// the permissions fName was given, e.g. by chmod.
+		perm = info.Mode().Perm()
+	}
+	dName := filepath.Dir(fName)
+	if err := os.MkdirAll(dName, 0775); err != nil {
+		return false, err
+	}
+	tmp, err := createTemp(dName, "."+filepath.Base(fName)+".tmp-", DefaultFileMode)
+	if err != nil {
+		return false, err
+	}
+	// cleanup removes the temporary file when something goes wrong.
+	cleanup := func(err error) (bool, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	if _, err := tmp.Write(src); err != nil {
+		return cleanup(err)
+	}
+	if perm != 0 {
+		if err := tmp.Chmod(perm); err != nil {
+			return cleanup(err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return cleanup(err)
+	}
+	if err := os.Rename(tmp.Name(), fName); err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	// NOTE: Syncing the directory makes the rename itself durable, not
+	// every platform supports it so errors are ignored.
+	if dir, err := os.Open(dName); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return true, nil
+}
+
+// createTemp creates a new file in dName whose name starts with prefix.
+// Unlike os.CreateTemp the file is created with perm, less the umask, so
+// it gets the permissions os.WriteFile would give it.
+func createTemp(dName string, prefix string, perm os.FileMode) (*os.File, error) {
+	for i := 0; i < 10000; i++ {
+		name := filepath.Join(dName, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("can't create a temporary file in %q", dName)
+}
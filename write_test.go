@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2022, Caltech
+All rights not granted herein are expressly reserved by Caltech.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package pandoc_client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dName := t.TempDir()
+	fName := filepath.Join(dName, "sub", "index.html")
+	written, err := writeFileAtomic(fName, []byte("<p>one</p>"), 0640)
+	if err != nil || !written {
+		t.Fatalf("expected the file to be written, %v", err)
+	}
+	info, err := os.Stat(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected mode 0640, got %o", info.Mode().Perm())
+	}
+
+	// Identical output leaves the file and its modification time alone
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(fName, past, past); err != nil {
+		t.Fatal(err)
+	}
+	written, err = writeFileAtomic(fName, []byte("<p>one</p>"), 0640)
+	if err != nil || written {
+		t.Errorf("expected identical output to be left alone, %v", err)
+	}
+	if info, _ := os.Stat(fName); !info.ModTime().Equal(past) {
+		t.Errorf("expected the modification time to stay %s, got %s", past, info.ModTime())
+	}
+
+	// New output replaces the file
+	written, err = writeFileAtomic(fName, []byte("<p>two</p>"), 0644)
+	if err != nil || !written {
+		t.Fatalf("expected the file to be replaced, %v", err)
+	}
+	if src, _ := os.ReadFile(fName); string(src) != "<p>two</p>" {
+		t.Errorf("expected the new content, got %q", src)
+	}
+	if info, _ := os.Stat(fName); info.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %o", info.Mode().Perm())
+	}
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(fName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only index.html, found %d files", len(entries))
+	}
+
+	// Without a mode files get the permissions os.WriteFile gives them
+	expected := umaskMode(t, dName)
+	fName = filepath.Join(dName, "about.html")
+	if _, err := writeFileAtomic(fName, []byte("<p>about</p>"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(fName); info.Mode().Perm() != expected {
+		t.Errorf("expected mode %o, got %o", expected, info.Mode().Perm())
+	}
+	// and an existing file keeps its permissions when it is unchanged
+	if err := os.Chmod(fName, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeFileAtomic(fName, []byte("<p>about</p>"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(fName); info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 to be kept, got %o", info.Mode().Perm())
+	}
+	// as does an existing file which is replaced
+	if _, err := writeFileAtomic(fName, []byte("<p>about us</p>"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if src, _ := os.ReadFile(fName); string(src) != "<p>about us</p>" {
+		t.Errorf("expected the new content, got %q", src)
+	}
+	if info, _ := os.Stat(fName); info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 to be kept when replaced, got %o", info.Mode().Perm())
+	}
+}
+
+// umaskMode returns the permissions os.WriteFile gives a new file
+// created with DefaultFileMode.
+func umaskMode(t *testing.T, dName string) os.FileMode {
+	t.Helper()
+	fName := filepath.Join(dName, "umask")
+	if err := os.WriteFile(fName, nil, DefaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fName)
+	info, err := os.Stat(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestWalkFileMode(t *testing.T) {
+	fp := newFakePandoc(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"index.md": "home"})
+	cfg := fp.config()
+	cfg.FileMode = "0600"
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	fName := filepath.Join(root, "index.html")
+	info, err := os.Stat(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	// Converting again without a manifest doesn't touch the file
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(fName, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(fName); !info.ModTime().Equal(past) {
+		t.Errorf("expected the modification time to stay %s, got %s", past, info.ModTime())
+	}
+
+	// The umask applies when FileMode isn't set
+	cfg.FileMode = ""
+	writeFiles(t, root, map[string]string{"about.md": "about"})
+	if err := cfg.Walk(root, ".md", ".html"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(filepath.Join(root, "about.html")); info.Mode().Perm() != umaskMode(t, root) {
+		t.Errorf("expected mode %o, got %o", umaskMode(t, root), info.Mode().Perm())
+	}
+
+	// A bad mode set from code is an error, not the default
+	for _, mode := range []string{"0999", "0000"} {
+		cfg.FileMode = mode
+		if err := cfg.Walk(root, ".md", ".html"); err == nil {
+			t.Errorf("expected an error for file mode %q", mode)
+		}
+	}
+
+	cfgName := filepath.Join(root, "config.json")
+	for src, ok := range map[string]bool{
+		`{"file_mode": "0644"}`:      true,
+		`{"file_mode": "640"}`:       true,
+		`{"file_mode": "0999"}`:      false,
+		`{"file_mode": "17777"}`:     false,
+		`{"file_mode": "rw-r--r--"}`: false,
+	} {
+		if err := os.WriteFile(cfgName, []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Load(cfgName)
+		if ok && err != nil {
+			t.Errorf("%s: %s", src, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}